@@ -6,6 +6,14 @@ package main
 
 // @host localhost:8080
 // @BasePath /api/
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
 import (
 	"database/sql"
 	"fmt"
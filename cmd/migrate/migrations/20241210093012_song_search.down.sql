@@ -0,0 +1,5 @@
+-- Drop the full-text search index, trigger and column
+DROP INDEX IF EXISTS idx_songs_searchVector;
+DROP TRIGGER IF EXISTS trg_songs_search_vector ON songs;
+DROP FUNCTION IF EXISTS songs_search_vector_update();
+ALTER TABLE songs DROP COLUMN IF EXISTS searchVector;
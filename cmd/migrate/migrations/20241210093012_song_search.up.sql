@@ -0,0 +1,24 @@
+-- Add a full-text search vector to `songs`
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS searchVector TSVECTOR;
+
+-- Keep the search vector in sync with the song name and lyrics
+CREATE OR REPLACE FUNCTION songs_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.searchVector :=
+        setweight(to_tsvector('simple', COALESCE(NEW.songName, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(array_to_string(NEW.songLyrics, ' '), '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_songs_search_vector
+    BEFORE INSERT OR UPDATE OF songName, songLyrics ON songs
+    FOR EACH ROW EXECUTE FUNCTION songs_search_vector_update();
+
+-- Backfill existing rows
+UPDATE songs SET searchVector =
+    setweight(to_tsvector('simple', COALESCE(songName, '')), 'A') ||
+    setweight(to_tsvector('simple', COALESCE(array_to_string(songLyrics, ' '), '')), 'B');
+
+-- GIN index for full-text lookups
+CREATE INDEX IF NOT EXISTS idx_songs_searchVector ON songs USING GIN (searchVector);
@@ -0,0 +1,5 @@
+-- Drop trigram indexes
+DROP INDEX IF EXISTS idx_groups_groupName_trgm;
+DROP INDEX IF EXISTS idx_songs_songName_trgm;
+
+-- The extension is left installed because other objects may depend on it
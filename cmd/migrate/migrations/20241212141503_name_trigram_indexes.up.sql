@@ -0,0 +1,6 @@
+-- Enable trigram matching for partial and fuzzy name lookups
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Trigram indexes on the lowercased names used by the song filters
+CREATE INDEX IF NOT EXISTS idx_songs_songName_trgm ON songs USING GIN (LOWER(songName) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_groups_groupName_trgm ON groups USING GIN (LOWER(groupName) gin_trgm_ops);
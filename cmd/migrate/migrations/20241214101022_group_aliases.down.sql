@@ -0,0 +1,5 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_group_aliases_groupId;
+
+-- Drop tables
+DROP TABLE IF EXISTS group_aliases;
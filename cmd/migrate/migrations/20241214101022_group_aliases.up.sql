@@ -0,0 +1,9 @@
+-- Create the `group_aliases` table mapping alternate spellings to a group
+CREATE TABLE IF NOT EXISTS group_aliases (
+                                             alias VARCHAR(255) PRIMARY KEY,
+                                             groupId INTEGER NOT NULL,
+                                             FOREIGN KEY (groupId) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+-- Index for finding the aliases of a group
+CREATE INDEX IF NOT EXISTS idx_group_aliases_groupId ON group_aliases(groupId);
@@ -0,0 +1,7 @@
+-- Restore the unique index on display names
+DROP INDEX IF EXISTS idx_groups_groupName;
+DROP INDEX IF EXISTS idx_groups_groupKey;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_groups_groupName ON groups(groupName);
+
+-- Drop the comparison key
+ALTER TABLE groups DROP COLUMN IF EXISTS groupKey;
@@ -0,0 +1,54 @@
+-- Add a canonical comparison key to `groups`; groupName stays the display name.
+-- The application computes keys with Unicode case folding and the optional
+-- leading "The" rule; the backfill below approximates that with LOWER, and the
+-- server recomputes every group and alias key with those rules when it starts.
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS groupKey VARCHAR(255);
+
+UPDATE groups SET
+    groupName = btrim(regexp_replace(normalize(groupName, NFC), '\s+', ' ', 'g')),
+    groupKey  = LOWER(btrim(regexp_replace(normalize(groupName, NFC), '\s+', ' ', 'g')));
+
+-- Collapse groups sharing a key onto the oldest one, keeping the other
+-- spellings as aliases
+CREATE TEMPORARY TABLE group_key_duplicates AS
+SELECT id, groupName, MIN(id) OVER (PARTITION BY groupKey) AS keepId
+FROM groups;
+
+UPDATE songs s SET songGroupId = d.keepId
+FROM group_key_duplicates d
+WHERE s.songGroupId = d.id AND d.id <> d.keepId;
+
+UPDATE group_aliases a SET groupId = d.keepId
+FROM group_key_duplicates d
+WHERE a.groupId = d.id AND d.id <> d.keepId;
+
+INSERT INTO group_aliases (alias, groupId)
+SELECT groupName, keepId FROM group_key_duplicates WHERE id <> keepId
+ON CONFLICT (alias) DO NOTHING;
+
+DELETE FROM groups g
+USING group_key_duplicates d
+WHERE g.id = d.id AND d.id <> d.keepId;
+
+DROP TABLE group_key_duplicates;
+
+ALTER TABLE groups ALTER COLUMN groupKey SET NOT NULL;
+
+-- The key is now the unique lookup; display names may differ only in case
+DROP INDEX IF EXISTS idx_groups_groupName;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_groups_groupKey ON groups(groupKey);
+CREATE INDEX IF NOT EXISTS idx_groups_groupName ON groups(groupName);
+
+-- Aliases are stored as comparison keys as well
+DELETE FROM group_aliases a
+USING group_aliases b
+WHERE a.alias > b.alias
+  AND LOWER(btrim(regexp_replace(normalize(a.alias, NFC), '\s+', ' ', 'g'))) =
+      LOWER(btrim(regexp_replace(normalize(b.alias, NFC), '\s+', ' ', 'g')));
+
+UPDATE group_aliases SET alias = LOWER(btrim(regexp_replace(normalize(alias, NFC), '\s+', ' ', 'g')));
+
+-- An alias equal to its group's own key is redundant
+DELETE FROM group_aliases a
+USING groups g
+WHERE a.groupId = g.id AND a.alias = g.groupKey;
@@ -0,0 +1,4 @@
+-- Drop the uniqueness constraint and the comparison key
+DROP INDEX IF EXISTS idx_songs_songGroupId_songKey;
+DROP TABLE IF EXISTS song_key_duplicates;
+ALTER TABLE songs DROP COLUMN IF EXISTS songKey;
@@ -0,0 +1,32 @@
+-- Add a normalized comparison key for song names; the application computes
+-- it with Unicode case folding, the backfill approximates that with LOWER
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS songKey VARCHAR(255);
+
+UPDATE songs SET songKey = LOWER(btrim(regexp_replace(normalize(songName, NFC), '\s+', ' ', 'g')));
+
+-- Songs already in the table twice are kept, and listed here for an operator
+-- to resolve: each later copy points at the oldest row
+CREATE TABLE IF NOT EXISTS song_key_duplicates (
+                                                   songId INTEGER PRIMARY KEY,
+                                                   duplicateOf INTEGER NOT NULL,
+                                                   FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE,
+                                                   FOREIGN KEY (duplicateOf) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+INSERT INTO song_key_duplicates (songId, duplicateOf)
+SELECT a.id, MIN(b.id)
+FROM songs a
+JOIN songs b ON a.songGroupId = b.songGroupId AND a.songKey = b.songKey AND b.id < a.id
+GROUP BY a.id
+ON CONFLICT (songId) DO NOTHING;
+
+-- The copies get a key no song name normalizes to, since keys never start
+-- with a space. Renaming a copy gives it a regular key again.
+UPDATE songs s SET songKey = ' ' || s.id
+FROM song_key_duplicates d
+WHERE s.id = d.songId;
+
+ALTER TABLE songs ALTER COLUMN songKey SET NOT NULL;
+
+-- A group can't have the same song twice
+CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_songGroupId_songKey ON songs(songGroupId, songKey);
@@ -0,0 +1,10 @@
+-- Drop indexes before dropping columns and tables
+DROP INDEX IF EXISTS idx_songs_albumId_trackNumber;
+DROP INDEX IF EXISTS idx_albums_groupId;
+
+-- Unlink songs from albums
+ALTER TABLE songs DROP COLUMN IF EXISTS trackNumber;
+ALTER TABLE songs DROP COLUMN IF EXISTS albumId;
+
+-- Drop tables
+DROP TABLE IF EXISTS albums;
@@ -0,0 +1,19 @@
+-- Create the `albums` table
+CREATE TABLE IF NOT EXISTS albums (
+                                      id SERIAL PRIMARY KEY,
+                                      title VARCHAR(255) NOT NULL,
+                                      groupId INTEGER NOT NULL,
+                                      releaseDate DATE,
+                                      coverUrl VARCHAR(255),
+                                      FOREIGN KEY (groupId) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+-- Index for listing the albums of a group
+CREATE INDEX IF NOT EXISTS idx_albums_groupId ON albums(groupId);
+
+-- Link songs to albums with a track number
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS albumId INTEGER REFERENCES albums(id) ON DELETE SET NULL;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS trackNumber INTEGER;
+
+-- A track number is used once per album
+CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_albumId_trackNumber ON songs(albumId, trackNumber);
@@ -0,0 +1,7 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_song_tags_tagId;
+DROP INDEX IF EXISTS idx_tags_kind_name;
+
+-- Drop tables
+DROP TABLE IF EXISTS song_tags;
+DROP TABLE IF EXISTS tags;
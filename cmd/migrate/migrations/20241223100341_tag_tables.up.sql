@@ -0,0 +1,21 @@
+-- Create the `tags` table holding both genres and free-form tags
+CREATE TABLE IF NOT EXISTS tags (
+                                    id SERIAL PRIMARY KEY,
+                                    name VARCHAR(100) NOT NULL,
+                                    kind VARCHAR(10) NOT NULL CHECK (kind IN ('genre', 'tag'))
+);
+
+-- A name is used once per kind
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_kind_name ON tags(kind, name);
+
+-- Create the `song_tags` join table
+CREATE TABLE IF NOT EXISTS song_tags (
+                                         songId INTEGER NOT NULL,
+                                         tagId INTEGER NOT NULL,
+                                         PRIMARY KEY (songId, tagId),
+                                         FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE,
+                                         FOREIGN KEY (tagId) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+-- Index for finding the songs of a tag
+CREATE INDEX IF NOT EXISTS idx_song_tags_tagId ON song_tags(tagId);
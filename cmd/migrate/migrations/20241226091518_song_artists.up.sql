@@ -0,0 +1,19 @@
+-- Create the `song_artists` join table; songs.songGroupId stays the main
+-- primary artist and is mirrored here with position 0
+CREATE TABLE IF NOT EXISTS song_artists (
+                                            songId INTEGER NOT NULL,
+                                            groupId INTEGER NOT NULL,
+                                            role VARCHAR(20) NOT NULL CHECK (role IN ('primary', 'featured', 'remixer')),
+                                            position INTEGER NOT NULL DEFAULT 0,
+                                            PRIMARY KEY (songId, groupId, role),
+                                            FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE,
+                                            FOREIGN KEY (groupId) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+-- Index for finding the songs of an artist
+CREATE INDEX IF NOT EXISTS idx_song_artists_groupId ON song_artists(groupId);
+
+-- Copy existing single-group songs
+INSERT INTO song_artists (songId, groupId, role, position)
+SELECT id, songGroupId, 'primary', 0 FROM songs
+ON CONFLICT DO NOTHING;
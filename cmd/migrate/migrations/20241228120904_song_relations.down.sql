@@ -0,0 +1,5 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_song_relations_originalId;
+
+-- Drop tables
+DROP TABLE IF EXISTS song_relations;
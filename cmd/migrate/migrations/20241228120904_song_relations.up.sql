@@ -0,0 +1,14 @@
+-- Create the `song_relations` table: songId is a cover, remix, live version
+-- or translation of originalId
+CREATE TABLE IF NOT EXISTS song_relations (
+                                              songId INTEGER NOT NULL,
+                                              originalId INTEGER NOT NULL,
+                                              kind VARCHAR(20) NOT NULL CHECK (kind IN ('cover', 'remix', 'live', 'translation')),
+                                              PRIMARY KEY (songId, originalId, kind),
+                                              CHECK (songId <> originalId),
+                                              FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE,
+                                              FOREIGN KEY (originalId) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+-- Index for walking from an original to its versions
+CREATE INDEX IF NOT EXISTS idx_song_relations_originalId ON song_relations(originalId);
@@ -0,0 +1,9 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_playlist_entries_songId;
+
+-- Drop tables
+DROP TABLE IF EXISTS playlist_entries;
+DROP TABLE IF EXISTS playlists;
+
+-- Drop song durations
+ALTER TABLE songs DROP COLUMN IF EXISTS duration;
@@ -0,0 +1,29 @@
+-- Song length in seconds, when the external API provides it
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS duration INTEGER CHECK (duration > 0);
+
+-- Create the `playlists` table; duplicates decides what happens when a song
+-- that is already on the playlist is added again
+CREATE TABLE IF NOT EXISTS playlists (
+                                         id SERIAL PRIMARY KEY,
+                                         name VARCHAR(255) NOT NULL,
+                                         description TEXT,
+                                         duplicates VARCHAR(10) NOT NULL DEFAULT 'reject' CHECK (duplicates IN ('allow', 'reject', 'skip')),
+                                         createdAt TIMESTAMP NOT NULL DEFAULT NOW(),
+                                         updatedAt TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create the `playlist_entries` table ordered by 1-based positions
+CREATE TABLE IF NOT EXISTS playlist_entries (
+                                                id SERIAL PRIMARY KEY,
+                                                playlistId INTEGER NOT NULL,
+                                                songId INTEGER NOT NULL,
+                                                position INTEGER NOT NULL CHECK (position > 0),
+                                                addedAt TIMESTAMP NOT NULL DEFAULT NOW(),
+                                                FOREIGN KEY (playlistId) REFERENCES playlists(id) ON DELETE CASCADE,
+                                                FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE,
+    -- Deferred so that moves and reorders can shift positions within a transaction
+                                                CONSTRAINT playlist_entries_position_key UNIQUE (playlistId, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- Index for finding the playlists a song is on
+CREATE INDEX IF NOT EXISTS idx_playlist_entries_songId ON playlist_entries(songId);
@@ -0,0 +1,5 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_users_username;
+
+-- Drop tables
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,11 @@
+-- Create the `users` table for API logins
+CREATE TABLE IF NOT EXISTS users (
+                                     id SERIAL PRIMARY KEY,
+                                     username VARCHAR(64) NOT NULL,
+                                     passwordHash VARCHAR(255) NOT NULL,
+                                     role VARCHAR(10) NOT NULL DEFAULT 'viewer' CHECK (role IN ('viewer', 'editor', 'admin')),
+                                     createdAt TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Usernames are unique regardless of case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(LOWER(username));
@@ -0,0 +1,5 @@
+-- Drop indexes before dropping tables
+DROP INDEX IF EXISTS idx_api_keys_keyHash;
+
+-- Drop tables
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,17 @@
+-- Create the `api_keys` table for machine clients; only a SHA-256 hash of
+-- each key is stored, prefix is kept to tell keys apart in listings
+CREATE TABLE IF NOT EXISTS api_keys (
+                                        id SERIAL PRIMARY KEY,
+                                        name VARCHAR(255) NOT NULL,
+                                        prefix VARCHAR(16) NOT NULL,
+                                        keyHash CHAR(64) NOT NULL,
+                                        scopes TEXT[] NOT NULL DEFAULT '{}',
+                                        createdBy INTEGER REFERENCES users(id) ON DELETE SET NULL,
+                                        createdAt TIMESTAMP NOT NULL DEFAULT NOW(),
+                                        lastUsedAt TIMESTAMP,
+                                        requestCount BIGINT NOT NULL DEFAULT 0,
+                                        revokedAt TIMESTAMP
+);
+
+-- Keys are looked up by hash on every request
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_keyHash ON api_keys(keyHash);
@@ -0,0 +1,10 @@
+-- Drop indexes before dropping columns
+DROP INDEX IF EXISTS idx_songs_deletedAt;
+DROP INDEX IF EXISTS idx_songs_songGroupId_songKey;
+
+-- Songs in the trash can't be kept without the column; purge them
+DELETE FROM songs WHERE deletedAt IS NOT NULL;
+ALTER TABLE songs DROP COLUMN IF EXISTS deletedAt;
+
+-- Restore the uniqueness constraint over all songs
+CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_songGroupId_songKey ON songs(songGroupId, songKey);
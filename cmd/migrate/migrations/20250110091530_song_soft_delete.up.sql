@@ -0,0 +1,10 @@
+-- Deleted songs stay in the table, in the trash, until they are purged
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMP;
+
+-- Only live songs have to be unique within a group, so a song can be added
+-- again while an older copy sits in the trash
+DROP INDEX IF EXISTS idx_songs_songGroupId_songKey;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_songGroupId_songKey ON songs(songGroupId, songKey) WHERE deletedAt IS NULL;
+
+-- Index for listing and purging the trash
+CREATE INDEX IF NOT EXISTS idx_songs_deletedAt ON songs(deletedAt) WHERE deletedAt IS NOT NULL;
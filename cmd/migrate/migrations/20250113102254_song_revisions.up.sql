@@ -0,0 +1,30 @@
+-- Create the `song_revisions` table; every change to a song stores a full
+-- snapshot of its editable fields together with who made it and when
+CREATE TABLE IF NOT EXISTS song_revisions (
+                                              id SERIAL PRIMARY KEY,
+                                              songId INTEGER NOT NULL,
+                                              revision INTEGER NOT NULL,
+                                              action VARCHAR(10) NOT NULL CHECK (action IN ('create', 'update', 'revert')),
+                                              changedBy VARCHAR(255),
+                                              changedAt TIMESTAMP NOT NULL DEFAULT NOW(),
+                                              changes TEXT[] NOT NULL DEFAULT '{}',
+                                              revertedFrom INTEGER,
+                                              snapshot JSONB NOT NULL,
+                                              UNIQUE (songId, revision),
+                                              FOREIGN KEY (songId) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+-- Start the history of existing songs with their current state
+INSERT INTO song_revisions (songId, revision, action, snapshot)
+SELECT s.id, 1, 'create', json_build_object(
+        'song', s.songName,
+        'group', g.groupName,
+        'artists', COALESCE((SELECT json_agg(json_build_object('name', ag.groupName, 'role', sa.role) ORDER BY sa.position)
+                             FROM song_artists sa JOIN groups ag ON sa.groupId = ag.id
+                             WHERE sa.songId = s.id), '[]'::json),
+        'songLyrics', COALESCE(to_json(s.songLyrics), '[]'::json),
+        'published', COALESCE(to_char(s.published, 'YYYY-MM-DD'), ''),
+        'link', COALESCE(s.link, ''))
+FROM songs s
+JOIN groups g ON s.songGroupId = g.id
+ON CONFLICT DO NOTHING;
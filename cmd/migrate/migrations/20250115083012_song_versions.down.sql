@@ -0,0 +1,2 @@
+-- Drop song versions
+ALTER TABLE songs DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+-- Every change to a song bumps its version, which is exposed as an ETag so
+-- concurrent editors can't overwrite each other's changes
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Drop the creation time of songs
+DROP INDEX IF EXISTS idx_songs_createdAt;
+ALTER TABLE songs DROP COLUMN IF EXISTS createdAt;
@@ -0,0 +1,9 @@
+-- Record when a song was added, for the most recently added songs
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS createdAt TIMESTAMP;
+
+-- Only songs added from now on get a creation time. Existing songs are left
+-- NULL: when they were added was never recorded.
+ALTER TABLE songs ALTER COLUMN createdAt SET DEFAULT NOW();
+
+-- Index for listing the most recently added live songs, unknown ones last
+CREATE INDEX IF NOT EXISTS idx_songs_createdAt ON songs(createdAt DESC NULLS LAST, id DESC) WHERE deletedAt IS NULL;
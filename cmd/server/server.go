@@ -2,13 +2,20 @@ package server
 
 import (
 	"database/sql"
+	"errors"
 	"github.com/genryusaishigikuni/muse_lib/config"
 	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/services/album"
+	"github.com/genryusaishigikuni/muse_lib/services/auth"
+	"github.com/genryusaishigikuni/muse_lib/services/group"
+	"github.com/genryusaishigikuni/muse_lib/services/playlist"
 	"github.com/genryusaishigikuni/muse_lib/services/song"
+	"github.com/genryusaishigikuni/muse_lib/services/stats"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type Server struct {
@@ -27,20 +34,67 @@ func (s *Server) Start() error {
 	logs := logger.SetupLogger(env)
 	logs.Info("Starting HTTP server", slog.String("address", s.addr), slog.String("operation", op))
 
+	if config.Envs.JWTSecret == "" {
+		err := errors.New("JWT_SECRET must be set")
+		logs.Error("Missing token signing key", logger.Err(err), slog.String("operation", op))
+		return err
+	}
+	secret := []byte(config.Envs.JWTSecret)
+
 	router := mux.NewRouter()
 	router.Use(handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"}),
-		handlers.AllowedHeaders([]string{"Content-Type"}),
+		handlers.AllowedOrigins(config.Envs.AllowedOrigins),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", auth.APIKeyHeader, "If-Match"}),
+		handlers.ExposedHeaders([]string{"ETag", "Content-Disposition"}),
 	))
 	router.Use(logger.New(logs))
 	logs.Debug("Router and middleware initialized", slog.String("operation", op))
 
+	authStore := auth.NewStore(s.db, env)
+	if err := bootstrapAdmin(authStore); err != nil {
+		logs.Error("Failed to bootstrap admin user", logger.Err(err), slog.String("operation", op))
+		return err
+	}
+
+	groupStore := group.NewStore(s.db, env)
+	if err := groupStore.RekeyGroups(); err != nil {
+		logs.Error("Failed to recompute group keys", logger.Err(err), slog.String("operation", op))
+		return err
+	}
+
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(auth.Middleware(secret, config.Envs.AnonymousReads, authStore, authStore, logs))
+	logs.Debug("Auth middleware initialized", slog.String("operation", op), slog.Bool("anonymous_reads", config.Envs.AnonymousReads))
+
+	authHandler := auth.NewHandler(authStore, authStore, secret, time.Duration(config.Envs.JWTExpiration)*time.Second, env)
+	authHandler.RegisterRoutes(apiRouter)
+	logs.Debug("Auth routes registered", slog.String("operation", op))
+
 	songStore := song.NewStore(s.db, env)
 	songHandler := song.NewHandler(songStore, env)
-	songHandler.RegisterRoutes(router.PathPrefix("/api").Subrouter())
+	songHandler.RegisterRoutes(apiRouter)
 	logs.Debug("Song routes registered", slog.String("operation", op))
 
+	groupHandler := group.NewHandler(groupStore, env)
+	groupHandler.RegisterRoutes(apiRouter)
+	logs.Debug("Group routes registered", slog.String("operation", op))
+
+	albumStore := album.NewStore(s.db, env)
+	albumHandler := album.NewHandler(albumStore, env)
+	albumHandler.RegisterRoutes(apiRouter)
+	logs.Debug("Album routes registered", slog.String("operation", op))
+
+	playlistStore := playlist.NewStore(s.db, env)
+	playlistHandler := playlist.NewHandler(playlistStore, env)
+	playlistHandler.RegisterRoutes(apiRouter)
+	logs.Debug("Playlist routes registered", slog.String("operation", op))
+
+	statsStore := stats.NewStore(s.db, env)
+	statsHandler := stats.NewHandler(statsStore, env)
+	statsHandler.RegisterRoutes(apiRouter)
+	logs.Debug("Statistics routes registered", slog.String("operation", op))
+
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 	logs.Info("Static file handler configured", slog.String("operation", op))
 
@@ -54,3 +108,17 @@ func (s *Server) Start() error {
 	logs.Info("Server stopped", slog.String("operation", op))
 	return nil
 }
+
+// bootstrapAdmin creates the admin from ADMIN_USERNAME and ADMIN_PASSWORD
+// when both are set and no admin exists yet.
+func bootstrapAdmin(store *auth.Store) error {
+	if config.Envs.AdminUsername == "" || config.Envs.AdminPassword == "" {
+		return nil
+	}
+	passwordHash, err := auth.HashPassword(config.Envs.AdminPassword)
+	if err != nil {
+		return err
+	}
+	_, err = store.EnsureAdmin(config.Envs.AdminUsername, passwordHash)
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/services/song"
+	_ "github.com/lib/pq" // PostgresSQL driver
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `Usage: songs <command> [flags]
+
+Commands:
+  import    Create songs from a CSV or NDJSON file
+  export    Write the songs matching a filter to a CSV, NDJSON or JSON file
+
+Run "songs <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	env := config.Envs.Environment
+	log := logger.SetupLogger(env)
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:], env, log)
+	case "export":
+		err = runExport(os.Args[2:], env, log)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Error("Command failed", "command", os.Args[1], logger.Err(err))
+		os.Exit(1)
+	}
+}
+
+// runImport imports a file the same way POST /api/songs/import does and
+// writes the report as JSON. It fails when any row failed.
+func runImport(args []string, env string, log *slog.Logger) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "file format, csv or ndjson; by default taken from the file extension")
+	dryRun := flags.Bool("dry-run", false, "validate the file without creating songs")
+	concurrency := flags.Int("concurrency", config.Envs.ImportConcurrency, "maximum number of concurrent requests to the external API")
+	reportPath := flags.String("report", "", "write the report to this file instead of stdout")
+	actor := flags.String("actor", "cli", "name recorded as the author of the created songs")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: songs import [flags] <file>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected exactly one file, got %d", flags.NArg())
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			*format = song.FormatCSV
+		case ".ndjson", ".jsonl":
+			*format = song.FormatNDJSON
+		default:
+			return fmt.Errorf("can't tell the format of %s, use -format", path)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	database, err := db.NewPostgresStorage(config.Envs.DBUser, config.Envs.DBPassword, config.Envs.DBAddress, config.Envs.DBName, "disable")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Info("Importing songs", "file", path, "format", *format, "dry_run", *dryRun, "concurrency", *concurrency)
+	importer := song.NewImporter(song.NewStore(database, env), *concurrency, env)
+	report, err := importer.Import(ctx, file, *format, *dryRun, *actor)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *reportPath != "" {
+		if out, err = os.Create(*reportPath); err != nil {
+			return err
+		}
+		defer func(out *os.File) {
+			_ = out.Close()
+		}(out)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	log.Info("Import finished", "total", report.Total, "created", report.Created, "valid", report.Valid,
+		"skipped", report.Skipped, "failed", report.Failed)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, report.Total)
+	}
+	return nil
+}
+
+// runExport writes the songs matching a filter to a file the same way
+// GET /api/songs/export does. An incomplete file is removed.
+func runExport(args []string, env string, log *slog.Logger) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "file format, csv, ndjson or json; by default taken from the file extension")
+	query := flags.String("query", "", "filters as a query string, as for GET /api/songs/get, e.g. 'group=Muse&year=2006'")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: songs export [flags] <file>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected exactly one file, got %d", flags.NArg())
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			*format = song.FormatCSV
+		case ".ndjson", ".jsonl":
+			*format = song.FormatNDJSON
+		case ".json":
+			*format = song.FormatJSON
+		default:
+			return fmt.Errorf("can't tell the format of %s, use -format", path)
+		}
+	}
+	filters, err := url.ParseQuery(*query)
+	if err != nil {
+		return fmt.Errorf("invalid -query: %w", err)
+	}
+
+	database, err := db.NewPostgresStorage(config.Envs.DBUser, config.Envs.DBPassword, config.Envs.DBAddress, config.Envs.DBName, "disable")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Exporting songs", "file", path, "format", *format, "filters", filters)
+	out := bufio.NewWriter(file)
+	count, err := song.Export(ctx, song.NewStore(database, env), out, *format, filters)
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	log.Info("Export finished", "file", path, "count", count)
+	return nil
+}
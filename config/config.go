@@ -5,6 +5,8 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -18,6 +20,27 @@ type Config struct {
 	DBName     string
 
 	ExtApi string
+
+	DefaultPageSize int
+	MaxPageSize     int
+
+	GroupStripThe bool
+
+	TrashRetentionDays int
+
+	AllowedOrigins []string
+
+	JWTSecret      string
+	JWTExpiration  int
+	AnonymousReads bool
+	AdminUsername  string
+	AdminPassword  string
+
+	ImportConcurrency int
+	ImportMaxRows     int
+
+	StatsCacheSeconds int
+	StatsTopGroups    int
 }
 
 var Envs = initConfig()
@@ -38,6 +61,27 @@ func initConfig() Config {
 		DBAddress:   fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "5432")),
 		DBName:      getEnv("DB_NAME", "muse_lib"),
 		ExtApi:      getEnv("EXT_API", "http://localhost:8081/info"),
+
+		DefaultPageSize: getEnvAsInt("DEFAULT_PAGE_SIZE", 10),
+		MaxPageSize:     getEnvAsInt("MAX_PAGE_SIZE", 100),
+
+		GroupStripThe: getEnvAsBool("GROUP_STRIP_THE", false),
+
+		TrashRetentionDays: getEnvAsInt("TRASH_RETENTION_DAYS", 30),
+
+		AllowedOrigins: getEnvAsList("ALLOWED_ORIGINS", []string{"http://localhost:8080"}),
+
+		JWTSecret:      getEnv("JWT_SECRET", ""),
+		JWTExpiration:  getEnvAsInt("JWT_EXPIRATION_SECONDS", 3600*24),
+		AnonymousReads: getEnvAsBool("ANONYMOUS_READS", false),
+		AdminUsername:  getEnv("ADMIN_USERNAME", ""),
+		AdminPassword:  getEnv("ADMIN_PASSWORD", ""),
+
+		ImportConcurrency: getEnvAsInt("IMPORT_CONCURRENCY", 4),
+		ImportMaxRows:     getEnvAsInt("IMPORT_MAX_ROWS", 10000),
+
+		StatsCacheSeconds: getEnvAsInt("STATS_CACHE_SECONDS", 60),
+		StatsTopGroups:    getEnvAsInt("STATS_TOP_GROUPS", 10),
 	}
 }
 
@@ -47,3 +91,40 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvAsInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid integer value for %s, using default %d", key, fallback)
+			return fallback
+		}
+		return i
+	}
+	return fallback
+}
+
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid boolean value for %s, using default %t", key, fallback)
+			return fallback
+		}
+		return b
+	}
+	return fallback
+}
+
+func getEnvAsList(key string, fallback []string) []string {
+	if value, ok := os.LookupEnv(key); ok {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return fallback
+}
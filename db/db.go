@@ -2,10 +2,13 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+const uniqueViolation = "23505"
+
 func NewPostgresStorage(user, password, address, dbname string, sslMode string) (*sql.DB, error) {
 	const op = "db.NewPostgresStorage"
 	dsn := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=%s",
@@ -19,3 +22,39 @@ func NewPostgresStorage(user, password, address, dbname string, sslMode string)
 
 	return db, nil
 }
+
+// WithTx runs fn inside a single transaction, committing when fn succeeds and
+// rolling back when it returns an error or panics.
+func WithTx(db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	const op = "db.WithTx"
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: failed to begin transaction: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: failed to commit transaction: %w", op, err)
+	}
+	return nil
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
@@ -0,0 +1,61 @@
+// Package httpapi holds the JSON request and response helpers shared by the
+// HTTP handlers of every service.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func ParseJson(r *http.Request, payload any) error {
+	if r.Body == nil {
+		return errors.New("missing request body")
+	}
+	return json.NewDecoder(r.Body).Decode(payload)
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func WriteError(w http.ResponseWriter, status int, err error) {
+	err = WriteJSON(w, status, map[string]string{"error": err.Error()})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// ParsePage reads offset and limit from the query, applying the default and
+// rejecting values outside the allowed range.
+func ParsePage(query url.Values, defaultLimit, maxLimit int) (int, int, error) {
+	offset, limit := 0, defaultLimit
+	if v := query.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return 0, 0, errors.New("invalid value for offset: must be a non-negative integer")
+		}
+		offset = o
+	}
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return 0, 0, errors.New("invalid value for limit: must be a positive integer")
+		}
+		limit = min(l, maxLimit)
+	}
+	return offset, limit, nil
+}
+
+// PageLink rebuilds the request URL with the given offset and limit.
+func PageLink(r *http.Request, offset, limit int) string {
+	query := r.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	return r.URL.Path + "?" + query.Encode()
+}
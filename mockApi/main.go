@@ -11,6 +11,7 @@ type SongDetail struct {
 	ReleaseDate string `json:"releaseDate"`
 	Text        string `json:"text"`
 	Link        string `json:"link"`
+	Duration    int    `json:"duration,omitempty"`
 }
 
 func infoHandler(w http.ResponseWriter, r *http.Request) {
@@ -26,6 +27,7 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 		ReleaseDate: "2006-07-16",
 		Text:        "Ooh baby, don't you know I suffer?\nOoh baby, can you hear me moan?\nYou caught me under false pretenses\nHow long before you let me go?\n\nOoh\nYou set my soul alight\nOoh\nYou set my soul alight",
 		Link:        "https://www.youtube.com/watch?v=Xsp3_a-PMTw",
+		Duration:    210,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,40 @@
+package normalize
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+)
+
+var folder = cases.Fold()
+
+// GroupName cleans up a group name for display: Unicode NFC, surrounding
+// whitespace trimmed and inner runs of whitespace collapsed to one space.
+func GroupName(name string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(name)), " ")
+}
+
+// GroupKey returns the comparison key for a group name. Names that differ
+// only in case, whitespace or Unicode composition share a key. With stripThe,
+// a leading "The" is ignored so "The Beatles" and "Beatles" collapse too.
+func GroupKey(name string, stripThe bool) string {
+	key := folder.String(GroupName(name))
+	if stripThe {
+		if rest, ok := strings.CutPrefix(key, "the "); ok && rest != "" {
+			key = rest
+		}
+	}
+	return key
+}
+
+// SongKey returns the comparison key for a song name, using the same cleanup
+// as GroupName followed by case folding.
+func SongKey(name string) string {
+	return folder.String(GroupName(name))
+}
+
+// Tag returns the stored form of a genre or tag name. Tags are compared and
+// displayed by the same key, so "Live" and " live " are one tag.
+func Tag(name string) string {
+	return folder.String(GroupName(name))
+}
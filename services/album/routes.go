@@ -0,0 +1,308 @@
+package album
+
+import (
+	"errors"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Handler struct {
+	store types.AlbumStore
+	logs  *slog.Logger
+}
+
+func NewHandler(albumStore types.AlbumStore, env string) *Handler {
+	return &Handler{
+		store: albumStore,
+		logs:  logger.SetupLogger(env),
+	}
+}
+
+// RegisterRoutes registers the album-related routes.
+//
+// @Summary Register album routes
+// @Description Adds routes for managing albums to the given router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/albums", h.HandleCreateAlbum).Methods("POST")
+	router.HandleFunc("/albums", h.HandleGetAlbums).Methods("GET")
+	router.HandleFunc("/albums/{id:[0-9]+}", h.HandleGetAlbum).Methods("GET")
+	router.HandleFunc("/albums/{id:[0-9]+}/tracks", h.HandleAttachSong).Methods("POST")
+	router.HandleFunc("/albums/{id:[0-9]+}/tracks", h.HandleDetachSong).Methods("DELETE")
+}
+
+// HandleCreateAlbum creates a new album.
+//
+// @Summary Create an album
+// @Description Creates an album for an existing group.
+// @Tags albums
+// @Accept json
+// @Produce json
+// @Param payload body types.AlbumCreatePayload true "Album data"
+// @Success 201 {string} string "Album created successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Group not found"
+// @Failure 500 {string} string "Failed to create album"
+// @Router /albums [post]
+func (h *Handler) HandleCreateAlbum(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleCreateAlbum"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	var payload types.AlbumCreatePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload.Title = strings.TrimSpace(payload.Title)
+	if payload.Title == "" || payload.GroupID <= 0 {
+		h.logs.Error("Missing title or group", "operation", op, "payload", payload)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("title and groupId must be provided"))
+		return
+	}
+
+	var releaseDate time.Time
+	if payload.ReleaseDate != "" {
+		var err error
+		releaseDate, err = time.Parse(types.DateLayout, payload.ReleaseDate)
+		if err != nil {
+			h.logs.Error("Invalid release date", "operation", op, "value", payload.ReleaseDate)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for releaseDate: must be a date in YYYY-MM-DD format"))
+			return
+		}
+	}
+
+	id, err := h.store.CreateAlbum(payload.Title, payload.GroupID, releaseDate, payload.CoverURL)
+	if err != nil {
+		h.logs.Error("Error creating album", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Album created successfully", "operation", op, "album_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]any{"status": "album created", "id": id}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetAlbums lists albums.
+//
+// @Summary List albums
+// @Description Returns a page of albums ordered by release date, optionally for a single group.
+// @Tags albums
+// @Produce json
+// @Param group_id query int false "Only list albums of this group"
+// @Param offset query int false "Offset for pagination"
+// @Param limit query int false "Maximum number of albums to return"
+// @Success 200 {object} types.AlbumPage "Albums retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to fetch albums"
+// @Router /albums [get]
+func (h *Handler) HandleGetAlbums(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetAlbums"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	offset, limit, err := httpapi.ParsePage(r.URL.Query(), config.Envs.DefaultPageSize, config.Envs.MaxPageSize)
+	if err != nil {
+		h.logs.Error("Invalid pagination parameters", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	groupID := 0
+	if v := r.URL.Query().Get("group_id"); v != "" {
+		groupID, err = strconv.Atoi(v)
+		if err != nil || groupID <= 0 {
+			h.logs.Error("Invalid group_id", "operation", op, "value", v)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for group_id: must be a positive integer"))
+			return
+		}
+	}
+
+	albums, total, err := h.store.GetAlbums(groupID, offset, limit)
+	if err != nil {
+		h.logs.Error("Error fetching albums", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	page := types.AlbumPage{
+		Items:  albums,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset+limit < total {
+		page.Links.Next = httpapi.PageLink(r, offset+limit, limit)
+	}
+	if offset > 0 {
+		page.Links.Prev = httpapi.PageLink(r, max(offset-limit, 0), limit)
+	}
+
+	h.logs.Debug("Albums retrieved", "operation", op, "count", len(albums), "total", total)
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetAlbum returns a single album with its tracks.
+//
+// @Summary Retrieve an album
+// @Description Returns an album with its songs in track order.
+// @Tags albums
+// @Produce json
+// @Param id path int true "ID of the album"
+// @Success 200 {object} types.Album "Album retrieved successfully"
+// @Failure 404 {string} string "Album not found"
+// @Failure 500 {string} string "Failed to fetch album"
+// @Router /albums/{id} [get]
+func (h *Handler) HandleGetAlbum(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetAlbum"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.albumID(w, r, op)
+	if !ok {
+		return
+	}
+
+	album, err := h.store.GetAlbum(id)
+	if err != nil {
+		h.logs.Error("Error fetching album", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, album); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleAttachSong adds a song to an album.
+//
+// @Summary Attach a song to an album
+// @Description Puts a song on the album with an optional track number, moving it off any previous album.
+// @Tags albums
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the album"
+// @Param payload body types.AlbumTrackPayload true "Song and track number"
+// @Success 200 {string} string "Song attached successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Album or song not found"
+// @Failure 409 {string} string "Track number already used"
+// @Failure 500 {string} string "Failed to attach song"
+// @Router /albums/{id}/tracks [post]
+func (h *Handler) HandleAttachSong(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleAttachSong"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.trackRequest(w, r, op)
+	if !ok {
+		return
+	}
+	if payload.TrackNumber < 0 {
+		h.logs.Error("Invalid track number", "operation", op, "trackNumber", payload.TrackNumber)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("trackNumber must be positive"))
+		return
+	}
+
+	if err := h.store.AttachSong(id, payload.SongID, payload.TrackNumber); err != nil {
+		h.logs.Error("Error attaching song", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Song attached successfully", "operation", op, "album_id", id, "song_id", payload.SongID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "song attached"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDetachSong removes a song from an album.
+//
+// @Summary Detach a song from an album
+// @Description Removes a song from the album; the song itself is kept.
+// @Tags albums
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the album"
+// @Param payload body types.AlbumTrackPayload true "Song to detach"
+// @Success 200 {string} string "Song detached successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not on album"
+// @Failure 500 {string} string "Failed to detach song"
+// @Router /albums/{id}/tracks [delete]
+func (h *Handler) HandleDetachSong(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDetachSong"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.trackRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DetachSong(id, payload.SongID); err != nil {
+		h.logs.Error("Error detaching song", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Song detached successfully", "operation", op, "album_id", id, "song_id", payload.SongID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "song detached"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// trackRequest reads the album ID and track payload, writing a 400 when
+// either is invalid.
+func (h *Handler) trackRequest(w http.ResponseWriter, r *http.Request, op string) (int, types.AlbumTrackPayload, bool) {
+	var payload types.AlbumTrackPayload
+
+	id, ok := h.albumID(w, r, op)
+	if !ok {
+		return 0, payload, false
+	}
+
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return 0, payload, false
+	}
+	if payload.SongID <= 0 {
+		h.logs.Error("Invalid song ID", "operation", op, "songId", payload.SongID)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("songId must be positive"))
+		return 0, payload, false
+	}
+	return id, payload, true
+}
+
+// albumID reads the album ID from the path, writing a 400 when it is invalid.
+func (h *Handler) albumID(w http.ResponseWriter, r *http.Request, op string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid album ID", "operation", op, "id", mux.Vars(r)["id"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for id: must be a positive integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+// storeErrorStatus maps errors returned by the store to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrAlbumNotFound), errors.Is(err, types.ErrSongNotFound), errors.Is(err, types.ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrTrackTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,241 @@
+package album
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/lib/pq"
+	"log/slog"
+	"time"
+)
+
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func NewStore(db *sql.DB, env string) *Store {
+	log := logger.SetupLogger(env)
+	const op = "album.NewStore"
+	log.Debug("Initializing new store", "operation", op)
+	return &Store{db: db, log: log}
+}
+
+func (s *Store) CreateAlbum(title string, groupID int, releaseDate time.Time, coverURL string) (int, error) {
+	const op = "album.CreateAlbum"
+	s.log.Info("Creating album", "operation", op, "title", title, "groupId", groupID)
+
+	var albumID int
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM groups WHERE id = $1)`, groupID).Scan(&exists); err != nil {
+			s.log.Error("Error checking group existence", "operation", op, "groupId", groupID, logger.Err(err))
+			return err
+		}
+		if !exists {
+			s.log.Warn("Group not found", "operation", op, "groupId", groupID)
+			return types.ErrGroupNotFound
+		}
+
+		query := `INSERT INTO albums (title, groupId, releaseDate, coverUrl)
+                  VALUES ($1, $2, $3, NULLIF($4, ''))
+                  RETURNING id`
+		err := tx.QueryRow(query, title, groupID, nullTime(releaseDate), coverURL).Scan(&albumID)
+		if err != nil {
+			s.log.Error("Error creating album", "operation", op, "title", title, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Album created successfully", "operation", op, "id", albumID)
+		return nil
+	})
+	return albumID, err
+}
+
+// GetAlbums lists albums ordered by release date. A groupID of 0 lists the
+// albums of every group.
+func (s *Store) GetAlbums(groupID, offset, limit int) ([]types.Album, int, error) {
+	const op = "album.GetAlbums"
+	s.log.Debug("Fetching albums", "operation", op, "groupId", groupID, "offset", offset, "limit", limit)
+
+	var total int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM albums WHERE $1 = 0 OR groupId = $1`, groupID).Scan(&total)
+	if err != nil {
+		s.log.Error("Error counting albums", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	query := `SELECT a.id, a.title, a.groupId, g.groupName, a.releaseDate, COALESCE(a.coverUrl, ''), COUNT(s.id)
+              FROM albums a
+              JOIN groups g ON a.groupId = g.id
+              LEFT JOIN songs s ON s.albumId = a.id AND s.deletedAt IS NULL
+              WHERE $1 = 0 OR a.groupId = $1
+              GROUP BY a.id, g.groupName
+              ORDER BY a.releaseDate NULLS LAST, a.id
+              OFFSET $2 LIMIT $3`
+	rows, err := s.db.Query(query, groupID, offset, limit)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	albums := make([]types.Album, 0, limit)
+	for rows.Next() {
+		var album types.Album
+		var releaseDate sql.NullTime
+		if err := rows.Scan(&album.ID, &album.Title, &album.GroupID, &album.Group, &releaseDate, &album.CoverURL, &album.TrackCount); err != nil {
+			s.log.Error("Error scanning album", "operation", op, logger.Err(err))
+			return nil, 0, err
+		}
+		album.ReleaseDate = formatDate(releaseDate)
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating albums", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	s.log.Debug("Fetched albums", "operation", op, "albums_count", len(albums), "total", total)
+	return albums, total, nil
+}
+
+func (s *Store) GetAlbum(id int) (*types.Album, error) {
+	const op = "album.GetAlbum"
+	s.log.Debug("Fetching album", "operation", op, "id", id)
+
+	var album types.Album
+	var releaseDate sql.NullTime
+	query := `SELECT a.id, a.title, a.groupId, g.groupName, a.releaseDate, COALESCE(a.coverUrl, '')
+              FROM albums a
+              JOIN groups g ON a.groupId = g.id
+              WHERE a.id = $1`
+	err := s.db.QueryRow(query, id).Scan(&album.ID, &album.Title, &album.GroupID, &album.Group, &releaseDate, &album.CoverURL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Album not found", "operation", op, "id", id)
+			return nil, types.ErrAlbumNotFound
+		}
+		s.log.Error("Error fetching album", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	album.ReleaseDate = formatDate(releaseDate)
+
+	query = `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link, s.trackNumber
+             FROM songs s
+             JOIN groups g ON s.songGroupId = g.id
+             WHERE s.albumId = $1 AND s.deletedAt IS NULL
+             ORDER BY s.trackNumber NULLS LAST, s.id`
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		s.log.Error("Error fetching album tracks", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	album.Tracks = []types.Song{}
+	for rows.Next() {
+		var song types.Song
+		var trackNumber sql.NullInt64
+		if err := rows.Scan(&song.ID, &song.SongName, &song.Group, pq.Array(&song.SongLyrics), &song.Published, &song.Link, &trackNumber); err != nil {
+			s.log.Error("Error scanning track", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		song.Album = &types.SongAlbum{ID: album.ID, Title: album.Title, TrackNumber: int(trackNumber.Int64)}
+		album.Tracks = append(album.Tracks, song)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating album tracks", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	album.TrackCount = len(album.Tracks)
+
+	s.log.Debug("Fetched album", "operation", op, "id", id, "tracks_count", album.TrackCount)
+	return &album, nil
+}
+
+// AttachSong puts a song on an album, moving it off any album it was on
+// before. A trackNumber of 0 leaves the track unnumbered.
+func (s *Store) AttachSong(albumID, songID, trackNumber int) error {
+	const op = "album.AttachSong"
+	s.log.Info("Attaching song to album", "operation", op, "albumId", albumID, "songId", songID, "trackNumber", trackNumber)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM albums WHERE id = $1)`, albumID).Scan(&exists); err != nil {
+			s.log.Error("Error checking album existence", "operation", op, "albumId", albumID, logger.Err(err))
+			return err
+		}
+		if !exists {
+			s.log.Warn("Album not found", "operation", op, "albumId", albumID)
+			return types.ErrAlbumNotFound
+		}
+
+		query := `UPDATE songs SET albumId = $1, trackNumber = NULLIF($2, 0) WHERE id = $3 AND deletedAt IS NULL`
+		result, err := tx.Exec(query, albumID, trackNumber, songID)
+		if err != nil {
+			if db.IsUniqueViolation(err) {
+				s.log.Warn("Track number already used", "operation", op, "albumId", albumID, "trackNumber", trackNumber)
+				return fmt.Errorf("track %d: %w", trackNumber, types.ErrTrackTaken)
+			}
+			s.log.Error("Error attaching song", "operation", op, logger.Err(err))
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+			return err
+		}
+		if rowsAffected == 0 {
+			s.log.Warn("Song not found", "operation", op, "songId", songID)
+			return types.ErrSongNotFound
+		}
+
+		s.log.Info("Song attached successfully", "operation", op, "albumId", albumID, "songId", songID)
+		return nil
+	})
+}
+
+func (s *Store) DetachSong(albumID, songID int) error {
+	const op = "album.DetachSong"
+	s.log.Info("Detaching song from album", "operation", op, "albumId", albumID, "songId", songID)
+
+	query := `UPDATE songs SET albumId = NULL, trackNumber = NULL WHERE id = $1 AND albumId = $2`
+	result, err := s.db.Exec(query, songID, albumID)
+	if err != nil {
+		s.log.Error("Error detaching song", "operation", op, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("Song not on album", "operation", op, "albumId", albumID, "songId", songID)
+		return fmt.Errorf("song %d is not on album %d: %w", songID, albumID, types.ErrSongNotFound)
+	}
+
+	s.log.Info("Song detached successfully", "operation", op, "albumId", albumID, "songId", songID)
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func formatDate(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(types.DateLayout)
+}
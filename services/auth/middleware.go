@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// rolePublic marks routes that need no authentication at all.
+const rolePublic = "public"
+
+// roleRank orders roles so that a higher role satisfies a lower requirement.
+var roleRank = map[string]int{
+	types.RoleViewer: 1,
+	types.RoleEditor: 2,
+	types.RoleAdmin:  3,
+}
+
+// routeRoles holds the roles declared with Require. Every other read needs a
+// viewer and every other write an editor.
+var (
+	routeRolesMu sync.RWMutex
+	routeRoles   = map[*mux.Route]string{}
+)
+
+// Require declares the role a route needs, overriding the default. Call it
+// where the route is registered, so the two can't drift apart.
+func Require(role string, route *mux.Route) *mux.Route {
+	routeRolesMu.Lock()
+	defer routeRolesMu.Unlock()
+	routeRoles[route] = role
+	return route
+}
+
+// Public marks a route that needs no authentication at all.
+func Public(route *mux.Route) *mux.Route {
+	return Require(rolePublic, route)
+}
+
+type (
+	contextKey       struct{}
+	apiKeyContextKey struct{}
+)
+
+// ClaimsFromContext returns the claims of the authenticated caller, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// APIKeyFromContext returns the API key the request was made with, if any.
+func APIKeyFromContext(ctx context.Context) (*types.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*types.APIKey)
+	return key, ok
+}
+
+// routeTemplate returns the mux path template of the matched route.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// requiredRole returns the role a request needs, or rolePublic.
+func requiredRole(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		routeRolesMu.RLock()
+		role, ok := routeRoles[route]
+		routeRolesMu.RUnlock()
+		if ok {
+			return role
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return types.RoleViewer
+	default:
+		return types.RoleEditor
+	}
+}
+
+// Middleware authenticates requests and enforces the role each route
+// requires. Users send a bearer token; their current name and role are
+// loaded for every request, so a token stops working as soon as its user is
+// deleted and role changes apply at once. Machine clients send an API key in
+// X-API-Key, whose scopes must cover the route and whose use is counted.
+// With anonymousReads, routes that only need a viewer can be called without
+// credentials; credentials that are sent must still be valid. The caller is
+// recorded with types.WithActor: the username, or the API key name prefixed
+// with "key:".
+func Middleware(secret []byte, anonymousReads bool, users types.UserStore, keys types.APIKeyStore, logs *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "auth.Middleware"
+
+			tmpl := routeTemplate(r)
+			required := requiredRole(r)
+			if required == rolePublic {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+				key, err := keys.UseAPIKey(HashAPIKey(apiKey))
+				if err != nil {
+					if errors.Is(err, types.ErrAPIKeyNotFound) {
+						logs.Warn("Rejected API key", "operation", op, "path", r.URL.Path)
+						unauthorized(w, errors.New("invalid or revoked API key"))
+						return
+					}
+					logs.Error("Error checking API key", "operation", op, logger.Err(err))
+					httpapi.WriteError(w, http.StatusInternalServerError, err)
+					return
+				}
+
+				scope := requiredScope(tmpl, required)
+				if scope == "" || !slices.Contains(key.Scopes, scope) {
+					logs.Warn("API key lacks scope", "operation", op, "key_id", key.ID, "scope", scope, "path", r.URL.Path)
+					httpapi.WriteError(w, http.StatusForbidden, errors.New("API key is not allowed to call this route"))
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), apiKeyContextKey{}, key)
+				next.ServeHTTP(w, r.WithContext(types.WithActor(ctx, "key:"+key.Name)))
+				return
+			}
+
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				if anonymousReads && required == types.RoleViewer {
+					next.ServeHTTP(w, r)
+					return
+				}
+				logs.Warn("Missing credentials", "operation", op, "path", r.URL.Path)
+				unauthorized(w, errors.New("authentication required"))
+				return
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				logs.Warn("Unsupported authorization scheme", "operation", op, "path", r.URL.Path)
+				unauthorized(w, errors.New("authorization header must be a bearer token"))
+				return
+			}
+			claims, err := ParseToken(secret, strings.TrimSpace(token))
+			if err != nil {
+				logs.Warn("Rejected token", "operation", op, "path", r.URL.Path, logger.Err(err))
+				unauthorized(w, err)
+				return
+			}
+			user, err := users.GetUser(claims.UserID)
+			if err != nil {
+				if errors.Is(err, types.ErrUserNotFound) {
+					logs.Warn("Token of a deleted user", "operation", op, "user_id", claims.UserID, "path", r.URL.Path)
+					unauthorized(w, errors.New("user no longer exists"))
+					return
+				}
+				logs.Error("Error loading user", "operation", op, logger.Err(err))
+				httpapi.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			claims.Username = user.Username
+			claims.Role = user.Role
+
+			if roleRank[claims.Role] < roleRank[required] {
+				logs.Warn("Insufficient role", "operation", op, "user", claims.Username, "role", claims.Role, "required", required)
+				httpapi.WriteError(w, http.StatusForbidden, errors.New("this action requires the "+required+" role"))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), contextKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(types.WithActor(ctx, claims.Username)))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="muse_lib"`)
+	httpapi.WriteError(w, http.StatusUnauthorized, err)
+}
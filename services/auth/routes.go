@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPasswordLength is the shortest password accepted for new users.
+const minPasswordLength = 8
+
+type Handler struct {
+	store  types.UserStore
+	keys   types.APIKeyStore
+	secret []byte
+	ttl    time.Duration
+	logs   *slog.Logger
+
+	// dummyHash is checked against when a login names an unknown user, so
+	// the response time does not reveal which usernames exist.
+	dummyHash string
+}
+
+func NewHandler(userStore types.UserStore, keyStore types.APIKeyStore, secret []byte, ttl time.Duration, env string) *Handler {
+	dummyHash, _ := HashPassword("not a real password")
+	return &Handler{
+		store:     userStore,
+		keys:      keyStore,
+		secret:    secret,
+		ttl:       ttl,
+		logs:      logger.SetupLogger(env),
+		dummyHash: dummyHash,
+	}
+}
+
+// RegisterRoutes registers the login, user management and API key routes.
+//
+// @Summary Register auth routes
+// @Description Adds routes for logging in and managing users and API keys to the given router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	Public(router.HandleFunc("/auth/login", h.HandleLogin).Methods("POST"))
+	router.HandleFunc("/auth/me", h.HandleMe).Methods("GET")
+	Require(types.RoleAdmin, router.HandleFunc("/users", h.HandleGetUsers).Methods("GET"))
+	Require(types.RoleAdmin, router.HandleFunc("/users", h.HandleCreateUser).Methods("POST"))
+	Require(types.RoleAdmin, router.HandleFunc("/users/{id:[0-9]+}", h.HandleDeleteUser).Methods("DELETE"))
+	Require(types.RoleAdmin, router.HandleFunc("/keys", h.HandleGetAPIKeys).Methods("GET"))
+	Require(types.RoleAdmin, router.HandleFunc("/keys", h.HandleCreateAPIKey).Methods("POST"))
+	Require(types.RoleAdmin, router.HandleFunc("/keys/{id:[0-9]+}", h.HandleRevokeAPIKey).Methods("DELETE"))
+}
+
+// HandleLogin exchanges a username and password for a token.
+//
+// @Summary Log in
+// @Description Checks the credentials and returns a signed JWT to send as "Authorization: Bearer <token>".
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body types.LoginPayload true "Credentials"
+// @Success 200 {object} types.LoginResponse "Logged in successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 401 {string} string "Invalid username or password"
+// @Failure 500 {string} string "Failed to log in"
+// @Router /auth/login [post]
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleLogin"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	var payload types.LoginPayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Username == "" || payload.Password == "" {
+		h.logs.Error("Missing credentials", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("username and password must be provided"))
+		return
+	}
+
+	user, passwordHash, err := h.store.GetCredentials(payload.Username)
+	if err != nil && !errors.Is(err, types.ErrUserNotFound) {
+		h.logs.Error("Error fetching credentials", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		passwordHash = h.dummyHash
+	}
+
+	match, err := CheckPassword(payload.Password, passwordHash)
+	if err != nil {
+		h.logs.Error("Error checking password", "operation", op, "username", payload.Username, logger.Err(err))
+		httpapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil || !match {
+		h.logs.Warn("Failed login", "operation", op, "username", payload.Username)
+		httpapi.WriteError(w, http.StatusUnauthorized, types.ErrInvalidCredentials)
+		return
+	}
+
+	token, expiresAt, err := IssueToken(h.secret, *user, h.ttl)
+	if err != nil {
+		h.logs.Error("Error issuing token", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logs.Info("User logged in", "operation", op, "user_id", user.ID, "role", user.Role)
+	response := types.LoginResponse{Token: token, TokenType: "Bearer", ExpiresAt: expiresAt, User: *user}
+	if err := httpapi.WriteJSON(w, http.StatusOK, response); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleMe returns the caller's identity.
+//
+// @Summary Current user
+// @Description Returns the claims of the token the request was made with, with the user's current name and role.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} auth.Claims "Caller identity"
+// @Failure 401 {string} string "Not authenticated"
+// @Router /auth/me [get]
+func (h *Handler) HandleMe(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleMe"
+
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		unauthorized(w, errors.New("authentication required"))
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, claims); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetUsers lists users.
+//
+// @Summary List users
+// @Description Returns every user with its role. Requires the admin role.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} types.User "Users retrieved successfully"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 500 {string} string "Failed to fetch users"
+// @Router /users [get]
+func (h *Handler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetUsers"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	users, err := h.store.GetUsers()
+	if err != nil {
+		h.logs.Error("Error fetching users", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, users); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleCreateUser creates a user.
+//
+// @Summary Create a user
+// @Description Creates a user with the viewer, editor or admin role. Requires the admin role.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body types.UserCreatePayload true "User data"
+// @Success 201 {string} string "User created successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 409 {string} string "Username already taken"
+// @Failure 500 {string} string "Failed to create user"
+// @Router /users [post]
+func (h *Handler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleCreateUser"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	var payload types.UserCreatePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload.Username = strings.TrimSpace(payload.Username)
+	if payload.Username == "" || len(payload.Username) > 64 {
+		h.logs.Error("Invalid username", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("username must be between 1 and 64 characters"))
+		return
+	}
+	if len(payload.Password) < minPasswordLength {
+		h.logs.Error("Password too short", "operation", op, "username", payload.Username)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("password must be at least 8 characters"))
+		return
+	}
+	if _, ok := roleRank[payload.Role]; !ok {
+		h.logs.Error("Invalid role", "operation", op, "role", payload.Role)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("role must be viewer, editor or admin"))
+		return
+	}
+
+	passwordHash, err := HashPassword(payload.Password)
+	if err != nil {
+		h.logs.Error("Error hashing password", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := h.store.CreateUser(payload.Username, passwordHash, payload.Role)
+	if err != nil {
+		h.logs.Error("Error creating user", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("User created successfully", "operation", op, "user_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]any{"status": "user created", "id": id}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDeleteUser deletes a user.
+//
+// @Summary Delete a user
+// @Description Deletes a user. Tokens already issued to it are rejected from then on. Requires the admin role.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID of the user"
+// @Success 200 {string} string "User deleted successfully"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Failed to delete user"
+// @Router /users/{id} [delete]
+func (h *Handler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDeleteUser"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid user ID", "operation", op, "id", mux.Vars(r)["id"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for id: must be a positive integer"))
+		return
+	}
+	if claims, ok := ClaimsFromContext(r.Context()); ok && claims.UserID == id {
+		h.logs.Warn("Refusing to delete own user", "operation", op, "user_id", id)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("cannot delete the user you are logged in as"))
+		return
+	}
+
+	if err := h.store.DeleteUser(id); err != nil {
+		h.logs.Error("Error deleting user", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("User deleted successfully", "operation", op, "user_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "user deleted"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetAPIKeys lists API keys.
+//
+// @Summary List API keys
+// @Description Returns every API key with its scopes, request count and last use, including revoked keys. Requires the admin role.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} types.APIKey "API keys retrieved successfully"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 500 {string} string "Failed to fetch API keys"
+// @Router /keys [get]
+func (h *Handler) HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetAPIKeys"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	keys, err := h.keys.GetAPIKeys()
+	if err != nil {
+		h.logs.Error("Error fetching API keys", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, keys); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleCreateAPIKey creates an API key.
+//
+// @Summary Create an API key
+// @Description Creates a key for a machine client. The key is returned once in the response and cannot be retrieved later. Requires the admin role.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body types.APIKeyCreatePayload true "Key name and scopes such as songs:read or songs:write"
+// @Success 201 {object} types.APIKeyCreated "API key created successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 500 {string} string "Failed to create API key"
+// @Router /keys [post]
+func (h *Handler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleCreateAPIKey"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	var payload types.APIKeyCreatePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		h.logs.Error("Missing key name", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("name must be provided"))
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		h.logs.Error("Missing scopes", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("at least one scope must be provided"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !validScopes[scope] {
+			h.logs.Error("Invalid scope", "operation", op, "scope", scope)
+			httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid scope %q: must be <resource>:read or <resource>:write for songs, groups, albums or playlists", scope))
+			return
+		}
+	}
+	slices.Sort(payload.Scopes)
+	payload.Scopes = slices.Compact(payload.Scopes)
+
+	plaintext, prefix, keyHash, err := NewAPIKey()
+	if err != nil {
+		h.logs.Error("Error generating API key", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	createdBy := 0
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		createdBy = claims.UserID
+	}
+
+	key, err := h.keys.CreateAPIKey(payload.Name, prefix, keyHash, payload.Scopes, createdBy)
+	if err != nil {
+		h.logs.Error("Error creating API key", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("API key created successfully", "operation", op, "key_id", key.ID, "prefix", prefix)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, types.APIKeyCreated{APIKey: *key, Key: plaintext}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRevokeAPIKey revokes an API key.
+//
+// @Summary Revoke an API key
+// @Description Disables an API key immediately. The key stays listed with its usage. Requires the admin role.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID of the API key"
+// @Success 200 {string} string "API key revoked successfully"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "Not authenticated"
+// @Failure 403 {string} string "Not an admin"
+// @Failure 404 {string} string "API key not found or already revoked"
+// @Failure 500 {string} string "Failed to revoke API key"
+// @Router /keys/{id} [delete]
+func (h *Handler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRevokeAPIKey"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid API key ID", "operation", op, "id", mux.Vars(r)["id"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for id: must be a positive integer"))
+		return
+	}
+
+	if err := h.keys.RevokeAPIKey(id); err != nil {
+		h.logs.Error("Error revoking API key", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("API key revoked successfully", "operation", op, "key_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "API key revoked"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// storeErrorStatus maps errors returned by the store to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrUserNotFound), errors.Is(err, types.ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrUserExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
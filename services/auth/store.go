@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/lib/pq"
+	"log/slog"
+)
+
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func NewStore(db *sql.DB, env string) *Store {
+	log := logger.SetupLogger(env)
+	const op = "auth.NewStore"
+	log.Debug("Initializing new store", "operation", op)
+	return &Store{db: db, log: log}
+}
+
+func (s *Store) CreateUser(username, passwordHash, role string) (int, error) {
+	const op = "auth.CreateUser"
+	s.log.Info("Creating user", "operation", op, "username", username, "role", role)
+
+	var id int
+	query := `INSERT INTO users (username, passwordHash, role) VALUES ($1, $2, $3) RETURNING id`
+	if err := s.db.QueryRow(query, username, passwordHash, role).Scan(&id); err != nil {
+		if db.IsUniqueViolation(err) {
+			s.log.Warn("Username already taken", "operation", op, "username", username)
+			return 0, types.ErrUserExists
+		}
+		s.log.Error("Error creating user", "operation", op, "username", username, logger.Err(err))
+		return 0, err
+	}
+
+	s.log.Info("User created successfully", "operation", op, "id", id)
+	return id, nil
+}
+
+func (s *Store) GetUsers() ([]types.User, error) {
+	const op = "auth.GetUsers"
+	s.log.Debug("Fetching users", "operation", op)
+
+	rows, err := s.db.Query(`SELECT id, username, role, createdAt FROM users ORDER BY id`)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	users := []types.User{}
+	for rows.Next() {
+		var user types.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+			s.log.Error("Error scanning user", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating users", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Fetched users", "operation", op, "users_count", len(users))
+	return users, nil
+}
+
+func (s *Store) DeleteUser(id int) error {
+	const op = "auth.DeleteUser"
+	s.log.Info("Deleting user", "operation", op, "id", id)
+
+	result, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		s.log.Error("Error deleting user", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("User not found", "operation", op, "id", id)
+		return types.ErrUserNotFound
+	}
+
+	s.log.Info("User deleted successfully", "operation", op, "id", id)
+	return nil
+}
+
+// GetUser returns a user by ID. The middleware calls it on every request
+// made with a token, so deleted users and role changes take effect at once.
+func (s *Store) GetUser(id int) (*types.User, error) {
+	const op = "auth.GetUser"
+
+	var user types.User
+	query := `SELECT id, username, role, createdAt FROM users WHERE id = $1`
+	err := s.db.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, types.ErrUserNotFound
+		}
+		s.log.Error("Error fetching user", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Store) GetCredentials(username string) (*types.User, string, error) {
+	const op = "auth.GetCredentials"
+	s.log.Debug("Fetching credentials", "operation", op, "username", username)
+
+	var user types.User
+	var passwordHash string
+	query := `SELECT id, username, role, createdAt, passwordHash FROM users WHERE LOWER(username) = LOWER($1)`
+	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", types.ErrUserNotFound
+		}
+		s.log.Error("Error fetching credentials", "operation", op, logger.Err(err))
+		return nil, "", err
+	}
+	return &user, passwordHash, nil
+}
+
+// EnsureAdmin creates an admin with the given credentials when there is no
+// admin yet, so a fresh installation can be bootstrapped from config. It
+// reports whether a user was created.
+func (s *Store) EnsureAdmin(username, passwordHash string) (bool, error) {
+	const op = "auth.EnsureAdmin"
+
+	created := false
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		// Serialize concurrent bootstraps of several instances
+		if _, err := tx.Exec(`LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE role = $1)`, types.RoleAdmin).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		query := `INSERT INTO users (username, passwordHash, role) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(query, username, passwordHash, types.RoleAdmin); err != nil {
+			if db.IsUniqueViolation(err) {
+				return types.ErrUserExists
+			}
+			return err
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Error bootstrapping admin", "operation", op, "username", username, logger.Err(err))
+		return false, err
+	}
+
+	if created {
+		s.log.Info("Admin user created", "operation", op, "username", username)
+	}
+	return created, nil
+}
+
+// apiKeyColumns are the columns scanned by scanAPIKey.
+const apiKeyColumns = `id, name, prefix, scopes, createdAt, lastUsedAt, requestCount, revokedAt`
+
+func scanAPIKey(row interface{ Scan(...any) error }, key *types.APIKey) error {
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &lastUsedAt, &key.RequestCount, &revokedAt)
+	if err != nil {
+		return err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return nil
+}
+
+// CreateAPIKey stores a new key by its hash. A createdBy of 0 records no
+// creator.
+func (s *Store) CreateAPIKey(name, prefix, keyHash string, scopes []string, createdBy int) (*types.APIKey, error) {
+	const op = "auth.CreateAPIKey"
+	s.log.Info("Creating API key", "operation", op, "name", name, "prefix", prefix, "scopes", scopes)
+
+	var key types.APIKey
+	query := `INSERT INTO api_keys (name, prefix, keyHash, scopes, createdBy)
+              VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+              RETURNING ` + apiKeyColumns
+	if err := scanAPIKey(s.db.QueryRow(query, name, prefix, keyHash, pq.Array(scopes), createdBy), &key); err != nil {
+		s.log.Error("Error creating API key", "operation", op, "name", name, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Info("API key created successfully", "operation", op, "id", key.ID)
+	return &key, nil
+}
+
+func (s *Store) GetAPIKeys() ([]types.APIKey, error) {
+	const op = "auth.GetAPIKeys"
+	s.log.Debug("Fetching API keys", "operation", op)
+
+	rows, err := s.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		var key types.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			s.log.Error("Error scanning API key", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating API keys", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Fetched API keys", "operation", op, "keys_count", len(keys))
+	return keys, nil
+}
+
+// RevokeAPIKey disables a key. The row is kept so its usage stays visible.
+func (s *Store) RevokeAPIKey(id int) error {
+	const op = "auth.RevokeAPIKey"
+	s.log.Info("Revoking API key", "operation", op, "id", id)
+
+	result, err := s.db.Exec(`UPDATE api_keys SET revokedAt = NOW() WHERE id = $1 AND revokedAt IS NULL`, id)
+	if err != nil {
+		s.log.Error("Error revoking API key", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("API key not found or already revoked", "operation", op, "id", id)
+		return types.ErrAPIKeyNotFound
+	}
+
+	s.log.Info("API key revoked successfully", "operation", op, "id", id)
+	return nil
+}
+
+// UseAPIKey looks up an active key and counts the request in the same
+// statement, so authenticating a machine client costs a single round trip.
+func (s *Store) UseAPIKey(keyHash string) (*types.APIKey, error) {
+	const op = "auth.UseAPIKey"
+
+	var key types.APIKey
+	query := `UPDATE api_keys SET requestCount = requestCount + 1, lastUsedAt = NOW()
+              WHERE keyHash = $1 AND revokedAt IS NULL
+              RETURNING ` + apiKeyColumns
+	if err := scanAPIKey(s.db.QueryRow(query, keyHash), &key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, types.ErrAPIKeyNotFound
+		}
+		s.log.Error("Error using API key", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims are the JWT claims identifying the caller of a request.
+type Claims struct {
+	UserID   int    `json:"uid"`
+	Username string `json:"name"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// IssueToken signs an HS256 JWT for user that expires after ttl.
+func IssueToken(secret []byte, user types.User, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseToken verifies the signature and expiry of a token made by IssueToken
+// and returns its claims. Only HS256 is accepted, which rules out "alg":
+// "none" and key confusion tricks.
+func ParseToken(secret []byte, token string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrTokenExpired
+	case err != nil:
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
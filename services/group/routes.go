@@ -0,0 +1,397 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/services/auth"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Handler struct {
+	store types.GroupStore
+	logs  *slog.Logger
+}
+
+func NewHandler(groupStore types.GroupStore, env string) *Handler {
+	return &Handler{
+		store: groupStore,
+		logs:  logger.SetupLogger(env),
+	}
+}
+
+// RegisterRoutes registers the group-related routes.
+//
+// @Summary Register group routes
+// @Description Adds routes for managing groups to the given router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/groups", h.HandleGetGroups).Methods("GET")
+	router.HandleFunc("/groups/{id:[0-9]+}", h.HandleGetGroup).Methods("GET")
+	router.HandleFunc("/groups/{id:[0-9]+}", h.HandleRenameGroup).Methods("PUT")
+	auth.Require(types.RoleAdmin, router.HandleFunc("/groups/{id:[0-9]+}", h.HandleDeleteGroup).Methods("DELETE"))
+	auth.Require(types.RoleAdmin, router.HandleFunc("/groups/merge", h.HandleMergeGroups).Methods("POST"))
+	router.HandleFunc("/groups/{id:[0-9]+}/aliases", h.HandleAddGroupAlias).Methods("POST")
+	router.HandleFunc("/groups/{id:[0-9]+}/aliases", h.HandleDeleteGroupAlias).Methods("DELETE")
+}
+
+// HandleGetGroups lists groups with their song counts.
+//
+// @Summary List groups
+// @Description Returns a page of groups ordered by name, each with the number of songs it takes part in under any role.
+// @Tags groups
+// @Produce json
+// @Param offset query int false "Offset for pagination"
+// @Param limit query int false "Maximum number of groups to return"
+// @Success 200 {object} types.GroupPage "Groups retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to fetch groups"
+// @Router /groups [get]
+func (h *Handler) HandleGetGroups(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetGroups"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	offset, limit, err := httpapi.ParsePage(r.URL.Query(), config.Envs.DefaultPageSize, config.Envs.MaxPageSize)
+	if err != nil {
+		h.logs.Error("Invalid pagination parameters", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	groups, total, err := h.store.GetGroups(offset, limit)
+	if err != nil {
+		h.logs.Error("Error fetching groups", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	page := types.GroupPage{
+		Items:  groups,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset+limit < total {
+		page.Links.Next = httpapi.PageLink(r, offset+limit, limit)
+	}
+	if offset > 0 {
+		page.Links.Prev = httpapi.PageLink(r, max(offset-limit, 0), limit)
+	}
+
+	h.logs.Debug("Groups retrieved", "operation", op, "count", len(groups), "total", total)
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetGroup returns a single group with its songs.
+//
+// @Summary Retrieve a group
+// @Description Returns a group together with all of its songs, including songs it is only featured on or remixed, each with the roles the group has on it.
+// @Tags groups
+// @Produce json
+// @Param id path int true "ID of the group"
+// @Success 200 {object} types.Group "Group retrieved successfully"
+// @Failure 404 {string} string "Group not found"
+// @Failure 500 {string} string "Failed to fetch group"
+// @Router /groups/{id} [get]
+func (h *Handler) HandleGetGroup(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetGroup"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.groupID(w, r, op)
+	if !ok {
+		return
+	}
+
+	group, err := h.store.GetGroup(id)
+	if err != nil {
+		h.logs.Error("Error fetching group", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, group); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRenameGroup renames a group.
+//
+// @Summary Rename a group
+// @Description Changes the name of a group; all of its songs follow.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the group"
+// @Param payload body types.GroupRenamePayload true "New group name"
+// @Success 200 {string} string "Group renamed successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Group not found"
+// @Failure 409 {string} string "Group name already taken"
+// @Failure 500 {string} string "Failed to rename group"
+// @Router /groups/{id} [put]
+func (h *Handler) HandleRenameGroup(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRenameGroup"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.groupID(w, r, op)
+	if !ok {
+		return
+	}
+
+	var payload types.GroupRenamePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		h.logs.Error("Empty group name", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("name must not be empty"))
+		return
+	}
+
+	if err := h.store.RenameGroup(id, payload.Name); err != nil {
+		h.logs.Error("Error renaming group", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Group renamed successfully", "operation", op, "group_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "group renamed"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDeleteGroup deletes a group.
+//
+// @Summary Delete a group
+// @Description Deletes a group. Groups with songs are refused unless cascade=true, which moves the songs to the trash. A group whose songs are in the trash is removed when the trash is purged.
+// @Tags groups
+// @Produce json
+// @Param id path int true "ID of the group"
+// @Param cascade query bool false "Move the group's songs to the trash"
+// @Success 200 {string} string "Group deleted successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Group not found"
+// @Failure 409 {string} string "Group still has songs"
+// @Failure 500 {string} string "Failed to delete group"
+// @Router /groups/{id} [delete]
+func (h *Handler) HandleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDeleteGroup"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.groupID(w, r, op)
+	if !ok {
+		return
+	}
+
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		var err error
+		cascade, err = strconv.ParseBool(v)
+		if err != nil {
+			h.logs.Error("Invalid cascade value", "operation", op, "value", v)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for cascade: must be a boolean"))
+			return
+		}
+	}
+
+	if err := h.store.DeleteGroup(id, cascade); err != nil {
+		h.logs.Error("Error deleting group", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Group deleted successfully", "operation", op, "group_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "group deleted"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleMergeGroups merges duplicate groups into one.
+//
+// @Summary Merge groups
+// @Description Moves all songs and albums of the source groups to the target group in one transaction and deletes the sources. Source names are kept as aliases of the target.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param payload body types.GroupMergePayload true "Target group and groups to merge into it"
+// @Success 200 {object} types.GroupMergeResult "Groups merged successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Group not found"
+// @Failure 409 {string} string "Merged groups share a song"
+// @Failure 500 {string} string "Failed to merge groups"
+// @Router /groups/merge [post]
+func (h *Handler) HandleMergeGroups(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleMergeGroups"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	var payload types.GroupMergePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if payload.TargetID <= 0 || len(payload.SourceIDs) == 0 {
+		h.logs.Error("Missing target or sources", "operation", op, "payload", payload)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("target and at least one source must be provided"))
+		return
+	}
+
+	seen := map[int]bool{payload.TargetID: true}
+	sources := make([]int, 0, len(payload.SourceIDs))
+	for _, id := range payload.SourceIDs {
+		if id <= 0 || id == payload.TargetID {
+			h.logs.Error("Invalid source group", "operation", op, "id", id)
+			httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid source %d: must be a positive ID other than the target", id))
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			sources = append(sources, id)
+		}
+	}
+
+	songsMoved, err := h.store.MergeGroups(payload.TargetID, sources)
+	if err != nil {
+		h.logs.Error("Error merging groups", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	result := types.GroupMergeResult{
+		TargetID:   payload.TargetID,
+		MergedIDs:  sources,
+		SongsMoved: songsMoved,
+	}
+	h.logs.Info("Groups merged successfully", "operation", op, "result", result)
+	if err := httpapi.WriteJSON(w, http.StatusOK, result); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleAddGroupAlias records an alternate spelling of a group.
+//
+// @Summary Add a group alias
+// @Description Maps an alternate spelling to the group so songs added under it join this group.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the group"
+// @Param payload body types.GroupAliasPayload true "Alternate spelling"
+// @Success 201 {string} string "Alias added successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Group not found"
+// @Failure 409 {string} string "Alias is the name or an alias of another group"
+// @Failure 500 {string} string "Failed to add alias"
+// @Router /groups/{id}/aliases [post]
+func (h *Handler) HandleAddGroupAlias(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleAddGroupAlias"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, alias, ok := h.aliasRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AddGroupAlias(id, alias); err != nil {
+		h.logs.Error("Error adding alias", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Alias added successfully", "operation", op, "group_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]string{"status": "alias added"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDeleteGroupAlias removes an alternate spelling of a group.
+//
+// @Summary Delete a group alias
+// @Description Removes an alternate spelling from the group.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the group"
+// @Param payload body types.GroupAliasPayload true "Alternate spelling"
+// @Success 200 {string} string "Alias deleted successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Alias not found"
+// @Failure 500 {string} string "Failed to delete alias"
+// @Router /groups/{id}/aliases [delete]
+func (h *Handler) HandleDeleteGroupAlias(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDeleteGroupAlias"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, alias, ok := h.aliasRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteGroupAlias(id, alias); err != nil {
+		h.logs.Error("Error deleting alias", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Alias deleted successfully", "operation", op, "group_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "alias deleted"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// aliasRequest reads the group ID and alias payload, writing a 400 when
+// either is invalid.
+func (h *Handler) aliasRequest(w http.ResponseWriter, r *http.Request, op string) (int, string, bool) {
+	id, ok := h.groupID(w, r, op)
+	if !ok {
+		return 0, "", false
+	}
+
+	var payload types.GroupAliasPayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return 0, "", false
+	}
+	if strings.TrimSpace(payload.Alias) == "" {
+		h.logs.Error("Empty alias", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("alias must not be empty"))
+		return 0, "", false
+	}
+	return id, payload.Alias, true
+}
+
+// groupID reads the group ID from the path, writing a 400 when it is invalid.
+func (h *Handler) groupID(w http.ResponseWriter, r *http.Request, op string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid group ID", "operation", op, "id", mux.Vars(r)["id"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for id: must be a positive integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+// storeErrorStatus maps errors returned by the store to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrGroupNotFound), errors.Is(err, types.ErrAliasNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrGroupExists), errors.Is(err, types.ErrGroupNotEmpty), errors.Is(err, types.ErrDuplicateSong):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,559 @@
+package group
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/normalize"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/lib/pq"
+	"log/slog"
+	"strings"
+)
+
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func NewStore(db *sql.DB, env string) *Store {
+	log := logger.SetupLogger(env)
+	const op = "group.NewStore"
+	log.Debug("Initializing new store", "operation", op)
+	return &Store{db: db, log: log}
+}
+
+func (s *Store) GetGroups(offset, limit int) ([]types.Group, int, error) {
+	const op = "group.GetGroups"
+	s.log.Debug("Fetching groups", "operation", op, "offset", offset, "limit", limit)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM groups`).Scan(&total); err != nil {
+		s.log.Error("Error counting groups", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	query := `SELECT g.id, g.groupName, COUNT(DISTINCT s.id)
+              FROM groups g
+              LEFT JOIN song_artists sa ON sa.groupId = g.id
+              LEFT JOIN songs s ON sa.songId = s.id AND s.deletedAt IS NULL
+              GROUP BY g.id
+              ORDER BY g.groupName, g.id
+              OFFSET $1 LIMIT $2`
+	rows, err := s.db.Query(query, offset, limit)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	groups := make([]types.Group, 0, limit)
+	for rows.Next() {
+		var group types.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.SongCount); err != nil {
+			s.log.Error("Error scanning group", "operation", op, logger.Err(err))
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating groups", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	s.log.Debug("Fetched groups", "operation", op, "groups_count", len(groups), "total", total)
+	return groups, total, nil
+}
+
+func (s *Store) GetGroup(id int) (*types.Group, error) {
+	const op = "group.GetGroup"
+	s.log.Debug("Fetching group", "operation", op, "id", id)
+
+	var group types.Group
+	err := s.db.QueryRow(`SELECT id, groupName FROM groups WHERE id = $1`, id).Scan(&group.ID, &group.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Group not found", "operation", op, "id", id)
+			return nil, types.ErrGroupNotFound
+		}
+		s.log.Error("Error fetching group", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+
+	aliasRows, err := s.db.Query(`SELECT alias FROM group_aliases WHERE groupId = $1 ORDER BY alias`, id)
+	if err != nil {
+		s.log.Error("Error fetching group aliases", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(aliasRows)
+
+	for aliasRows.Next() {
+		var alias string
+		if err := aliasRows.Scan(&alias); err != nil {
+			s.log.Error("Error scanning alias", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		group.Aliases = append(group.Aliases, alias)
+	}
+	if err := aliasRows.Err(); err != nil {
+		s.log.Error("Error iterating group aliases", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	// Songs where the group is only a featured artist or remixer count too
+	query := `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link,
+                     array_agg(sa.role ORDER BY sa.position, sa.role)
+              FROM song_artists sa
+              JOIN songs s ON sa.songId = s.id
+              JOIN groups g ON s.songGroupId = g.id
+              WHERE sa.groupId = $1 AND s.deletedAt IS NULL
+              GROUP BY s.id, g.groupName
+              ORDER BY s.id`
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		s.log.Error("Error fetching group songs", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	group.Songs = []types.GroupSong{}
+	for rows.Next() {
+		var song types.GroupSong
+		if err := rows.Scan(&song.ID, &song.SongName, &song.Group, pq.Array(&song.SongLyrics), &song.Published, &song.Link, pq.Array(&song.Roles)); err != nil {
+			s.log.Error("Error scanning song", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		group.Songs = append(group.Songs, song)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating group songs", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	group.SongCount = len(group.Songs)
+
+	s.log.Debug("Fetched group", "operation", op, "id", id, "songs_count", group.SongCount)
+	return &group, nil
+}
+
+func (s *Store) RenameGroup(id int, name string) error {
+	const op = "group.RenameGroup"
+	s.log.Info("Renaming group", "operation", op, "id", id, "name", name)
+
+	query := `UPDATE groups SET groupName = $1, groupKey = $2 WHERE id = $3`
+	result, err := s.db.Exec(query, normalize.GroupName(name), normalize.GroupKey(name, config.Envs.GroupStripThe), id)
+	if err != nil {
+		if db.IsUniqueViolation(err) {
+			s.log.Warn("Group name already taken", "operation", op, "name", name)
+			return types.ErrGroupExists
+		}
+		s.log.Error("Error renaming group", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("Group not found", "operation", op, "id", id)
+		return types.ErrGroupNotFound
+	}
+
+	s.log.Info("Group renamed successfully", "operation", op, "id", id, "name", name)
+	return nil
+}
+
+// DeleteGroup removes a group. Unless cascade is set, a group that still has
+// songs outside the trash is left untouched and ErrGroupNotEmpty is returned.
+// With cascade, those songs are moved to the trash. A group with songs in the
+// trash is kept until PurgeTrash removes it along with its last song.
+func (s *Store) DeleteGroup(id int, cascade bool) error {
+	const op = "group.DeleteGroup"
+	s.log.Info("Deleting group", "operation", op, "id", id, "cascade", cascade)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		var groupID int
+		err := tx.QueryRow(`SELECT id FROM groups WHERE id = $1 FOR UPDATE`, id).Scan(&groupID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.log.Warn("Group not found", "operation", op, "id", id)
+				return types.ErrGroupNotFound
+			}
+			s.log.Error("Error locking group", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+
+		if cascade {
+			result, err := tx.Exec(`UPDATE songs SET deletedAt = NOW() WHERE songGroupId = $1 AND deletedAt IS NULL`, id)
+			if err != nil {
+				s.log.Error("Error moving group songs to trash", "operation", op, "id", id, logger.Err(err))
+				return err
+			}
+			trashed, _ := result.RowsAffected()
+			s.log.Info("Moved group songs to trash", "operation", op, "id", id, "songs_count", trashed)
+		} else {
+			var songCount int
+			err := tx.QueryRow(`SELECT COUNT(*) FROM songs WHERE songGroupId = $1 AND deletedAt IS NULL`, id).Scan(&songCount)
+			if err != nil {
+				s.log.Error("Error counting group songs", "operation", op, "id", id, logger.Err(err))
+				return err
+			}
+			if songCount > 0 {
+				s.log.Warn("Refusing to delete group with songs", "operation", op, "id", id, "songs_count", songCount)
+				return types.ErrGroupNotEmpty
+			}
+		}
+
+		// Deleting the group now would take its trashed songs with it through
+		// the ON DELETE CASCADE on songs.songGroupId
+		var inTrash bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM songs WHERE songGroupId = $1)`, id).Scan(&inTrash)
+		if err != nil {
+			s.log.Error("Error checking trashed songs", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+		if inTrash {
+			s.log.Info("Group kept until its songs are purged from the trash", "operation", op, "id", id)
+			return nil
+		}
+
+		if _, err := tx.Exec(`DELETE FROM groups WHERE id = $1`, id); err != nil {
+			s.log.Error("Error deleting group", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Group deleted successfully", "operation", op, "id", id)
+		return nil
+	})
+}
+
+// MergeGroups moves every song and album of the source groups to the target
+// group and deletes the sources. Their keys are kept as aliases of the target so later
+// writes using an old spelling resolve to the surviving group. It returns the
+// number of songs moved.
+func (s *Store) MergeGroups(targetID int, sourceIDs []int) (int, error) {
+	const op = "group.MergeGroups"
+	s.log.Info("Merging groups", "operation", op, "target", targetID, "sources", sourceIDs)
+
+	var songsMoved int
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		ids := append([]int{targetID}, sourceIDs...)
+		rows, err := tx.Query(`SELECT id, groupKey FROM groups WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(ids))
+		if err != nil {
+			s.log.Error("Error locking groups", "operation", op, logger.Err(err))
+			return err
+		}
+		keys := make(map[int]string, len(ids))
+		for rows.Next() {
+			var id int
+			var key string
+			if err := rows.Scan(&id, &key); err != nil {
+				_ = rows.Close()
+				s.log.Error("Error scanning group", "operation", op, logger.Err(err))
+				return err
+			}
+			keys[id] = key
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, ok := keys[id]; !ok {
+				s.log.Warn("Group not found", "operation", op, "id", id)
+				return fmt.Errorf("group with ID %d: %w", id, types.ErrGroupNotFound)
+			}
+		}
+
+		result, err := tx.Exec(`UPDATE songs SET songGroupId = $1 WHERE songGroupId = ANY($2)`, targetID, pq.Array(sourceIDs))
+		if err != nil {
+			if db.IsUniqueViolation(err) {
+				s.log.Warn("Merge would duplicate a song", "operation", op, "target", targetID)
+				return fmt.Errorf("merged groups share a song, delete one copy first: %w", types.ErrDuplicateSong)
+			}
+			s.log.Error("Error reassigning songs", "operation", op, logger.Err(err))
+			return err
+		}
+		moved, err := result.RowsAffected()
+		if err != nil {
+			s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+			return err
+		}
+		songsMoved = int(moved)
+
+		// Artist credits follow the songs; drop those the target already has.
+		query := `DELETE FROM song_artists a
+                  WHERE a.groupId = ANY($2)
+                    AND EXISTS (SELECT 1 FROM song_artists b
+                                WHERE b.songId = a.songId AND b.role = a.role AND b.groupId = $1)`
+		if _, err := tx.Exec(query, targetID, pq.Array(sourceIDs)); err != nil {
+			s.log.Error("Error removing duplicate artist credits", "operation", op, logger.Err(err))
+			return err
+		}
+		query = `DELETE FROM song_artists a
+                 WHERE a.groupId = ANY($1)
+                   AND a.ctid <> (SELECT b.ctid FROM song_artists b
+                                  WHERE b.songId = a.songId AND b.role = a.role AND b.groupId = ANY($1)
+                                  ORDER BY b.position LIMIT 1)`
+		if _, err := tx.Exec(query, pq.Array(sourceIDs)); err != nil {
+			s.log.Error("Error removing duplicate artist credits", "operation", op, logger.Err(err))
+			return err
+		}
+		_, err = tx.Exec(`UPDATE song_artists SET groupId = $1 WHERE groupId = ANY($2)`, targetID, pq.Array(sourceIDs))
+		if err != nil {
+			s.log.Error("Error reassigning artist credits", "operation", op, logger.Err(err))
+			return err
+		}
+
+		// Albums of the sources would go with them through the ON DELETE CASCADE
+		// on albums.groupId.
+		_, err = tx.Exec(`UPDATE albums SET groupId = $1 WHERE groupId = ANY($2)`, targetID, pq.Array(sourceIDs))
+		if err != nil {
+			s.log.Error("Error reassigning albums", "operation", op, logger.Err(err))
+			return err
+		}
+
+		// Aliases of the sources now point at the target.
+		_, err = tx.Exec(`UPDATE group_aliases SET groupId = $1 WHERE groupId = ANY($2)`, targetID, pq.Array(sourceIDs))
+		if err != nil {
+			s.log.Error("Error repointing aliases", "operation", op, logger.Err(err))
+			return err
+		}
+
+		for _, id := range sourceIDs {
+			query := `INSERT INTO group_aliases (alias, groupId) VALUES ($1, $2)
+                      ON CONFLICT (alias) DO UPDATE SET groupId = EXCLUDED.groupId`
+			if _, err := tx.Exec(query, keys[id], targetID); err != nil {
+				s.log.Error("Error recording alias", "operation", op, "alias", keys[id], logger.Err(err))
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(`DELETE FROM groups WHERE id = ANY($1)`, pq.Array(sourceIDs)); err != nil {
+			s.log.Error("Error deleting source groups", "operation", op, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Groups merged successfully", "operation", op, "target", targetID, "songs_moved", songsMoved)
+		return nil
+	})
+	return songsMoved, err
+}
+
+// AddGroupAlias records an alternate spelling of a group. The alias is stored
+// as a normalized key and must not be the key of another group.
+func (s *Store) AddGroupAlias(id int, alias string) error {
+	const op = "group.AddGroupAlias"
+	key := normalize.GroupKey(alias, config.Envs.GroupStripThe)
+	s.log.Info("Adding group alias", "operation", op, "id", id, "alias", key)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		var groupKey string
+		err := tx.QueryRow(`SELECT groupKey FROM groups WHERE id = $1 FOR UPDATE`, id).Scan(&groupKey)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.log.Warn("Group not found", "operation", op, "id", id)
+				return types.ErrGroupNotFound
+			}
+			s.log.Error("Error locking group", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+		if key == groupKey {
+			return nil
+		}
+
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM groups WHERE groupKey = $1)`, key).Scan(&taken)
+		if err != nil {
+			s.log.Error("Error checking group key", "operation", op, "alias", key, logger.Err(err))
+			return err
+		}
+		if taken {
+			s.log.Warn("Alias matches another group", "operation", op, "alias", key)
+			return types.ErrGroupExists
+		}
+
+		query := `INSERT INTO group_aliases (alias, groupId) VALUES ($1, $2)
+                  ON CONFLICT (alias) DO NOTHING`
+		if _, err := tx.Exec(query, key, id); err != nil {
+			s.log.Error("Error adding alias", "operation", op, "alias", key, logger.Err(err))
+			return err
+		}
+
+		// An alias stays with its group; only merging groups moves it
+		var owner int
+		if err := tx.QueryRow(`SELECT groupId FROM group_aliases WHERE alias = $1`, key).Scan(&owner); err != nil {
+			s.log.Error("Error checking alias owner", "operation", op, "alias", key, logger.Err(err))
+			return err
+		}
+		if owner != id {
+			s.log.Warn("Alias belongs to another group", "operation", op, "alias", key, "owner", owner)
+			return fmt.Errorf("alias '%s' belongs to group %d: %w", alias, owner, types.ErrGroupExists)
+		}
+
+		s.log.Info("Group alias added successfully", "operation", op, "id", id, "alias", key)
+		return nil
+	})
+}
+
+func (s *Store) DeleteGroupAlias(id int, alias string) error {
+	const op = "group.DeleteGroupAlias"
+	key := normalize.GroupKey(alias, config.Envs.GroupStripThe)
+	s.log.Info("Deleting group alias", "operation", op, "id", id, "alias", key)
+
+	result, err := s.db.Exec(`DELETE FROM group_aliases WHERE alias = $1 AND groupId = $2`, key, id)
+	if err != nil {
+		s.log.Error("Error deleting alias", "operation", op, "alias", key, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("Alias not found", "operation", op, "id", id, "alias", key)
+		return types.ErrAliasNotFound
+	}
+
+	s.log.Info("Group alias deleted successfully", "operation", op, "id", id, "alias", key)
+	return nil
+}
+
+// RekeyGroups recomputes the key of every group and alias with the current
+// normalization settings. The migrations could only approximate the keys in
+// SQL, and changing GROUP_STRIP_THE changes them, so the server calls this
+// when it starts. When two groups, or an alias and another group, would end up
+// with the same key nothing is changed and ErrGroupExists is returned naming
+// them, so they can be merged first.
+func (s *Store) RekeyGroups() error {
+	const op = "group.RekeyGroups"
+	s.log.Info("Recomputing group keys", "operation", op, "strip_the", config.Envs.GroupStripThe)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id, groupName, groupKey FROM groups ORDER BY id FOR UPDATE`)
+		if err != nil {
+			s.log.Error("Error locking groups", "operation", op, logger.Err(err))
+			return err
+		}
+		owners := make(map[string]int)
+		var conflicts []string
+		var groupIDs []int
+		var groupKeys []string
+		for rows.Next() {
+			var id int
+			var name, current string
+			if err := rows.Scan(&id, &name, &current); err != nil {
+				_ = rows.Close()
+				s.log.Error("Error scanning group", "operation", op, logger.Err(err))
+				return err
+			}
+			key := normalize.GroupKey(name, config.Envs.GroupStripThe)
+			if other, ok := owners[key]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("groups %d and %d share the key '%s'", other, id, key))
+				continue
+			}
+			owners[key] = id
+			if key != current {
+				groupIDs = append(groupIDs, id)
+				groupKeys = append(groupKeys, key)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		rows, err = tx.Query(`SELECT alias, groupId FROM group_aliases ORDER BY alias FOR UPDATE`)
+		if err != nil {
+			s.log.Error("Error locking aliases", "operation", op, logger.Err(err))
+			return err
+		}
+		aliasOwners := make(map[string]int)
+		var stale, aliasKeys []string
+		var aliasGroupIDs []int
+		for rows.Next() {
+			var alias string
+			var groupID int
+			if err := rows.Scan(&alias, &groupID); err != nil {
+				_ = rows.Close()
+				s.log.Error("Error scanning alias", "operation", op, logger.Err(err))
+				return err
+			}
+			key := normalize.GroupKey(alias, config.Envs.GroupStripThe)
+			if owner, ok := owners[key]; ok {
+				if owner != groupID {
+					conflicts = append(conflicts, fmt.Sprintf("alias '%s' of group %d is the key of group %d", key, groupID, owner))
+				}
+				// An alias equal to its group's own key is redundant
+				stale = append(stale, alias)
+				continue
+			}
+			if other, ok := aliasOwners[key]; ok {
+				if other != groupID {
+					conflicts = append(conflicts, fmt.Sprintf("alias '%s' belongs to groups %d and %d", key, other, groupID))
+				}
+				stale = append(stale, alias)
+				continue
+			}
+			aliasOwners[key] = groupID
+			if key != alias {
+				stale = append(stale, alias)
+				aliasKeys = append(aliasKeys, key)
+				aliasGroupIDs = append(aliasGroupIDs, groupID)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		if len(conflicts) > 0 {
+			err := fmt.Errorf("%s, merge them first: %w", strings.Join(conflicts, "; "), types.ErrGroupExists)
+			s.log.Error("Group keys collide", "operation", op, logger.Err(err))
+			return err
+		}
+
+		// Changed keys go through a placeholder first, so two groups can swap
+		// keys without tripping the unique index. Normalized keys never start
+		// with a space.
+		if len(groupIDs) > 0 {
+			if _, err := tx.Exec(`UPDATE groups SET groupKey = ' ' || id WHERE id = ANY($1)`, pq.Array(groupIDs)); err != nil {
+				s.log.Error("Error clearing group keys", "operation", op, logger.Err(err))
+				return err
+			}
+			query := `UPDATE groups g SET groupKey = k.key
+                      FROM unnest($1::int[], $2::text[]) AS k(id, key)
+                      WHERE g.id = k.id`
+			if _, err := tx.Exec(query, pq.Array(groupIDs), pq.Array(groupKeys)); err != nil {
+				s.log.Error("Error updating group keys", "operation", op, logger.Err(err))
+				return err
+			}
+		}
+		if len(stale) > 0 {
+			if _, err := tx.Exec(`DELETE FROM group_aliases WHERE alias = ANY($1)`, pq.Array(stale)); err != nil {
+				s.log.Error("Error removing stale aliases", "operation", op, logger.Err(err))
+				return err
+			}
+		}
+		if len(aliasKeys) > 0 {
+			query := `INSERT INTO group_aliases (alias, groupId)
+                      SELECT * FROM unnest($1::text[], $2::int[])`
+			if _, err := tx.Exec(query, pq.Array(aliasKeys), pq.Array(aliasGroupIDs)); err != nil {
+				s.log.Error("Error recording aliases", "operation", op, logger.Err(err))
+				return err
+			}
+		}
+
+		s.log.Info("Group keys recomputed", "operation", op, "groups_changed", len(groupIDs), "aliases_changed", len(stale))
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package playlist
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"io"
+	"strings"
+)
+
+const (
+	FormatM3U  = "m3u"
+	FormatXSPF = "xspf"
+)
+
+// exportContentTypes maps each export format to its media type.
+var exportContentTypes = map[string]string{
+	FormatM3U:  "audio/x-mpegurl",
+	FormatXSPF: "application/xspf+xml",
+}
+
+// writeM3U writes the playlist as extended M3U. Entries without a link have
+// nothing to point at and are left out.
+func writeM3U(w io.Writer, p *types.Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Name))
+	for _, e := range p.Entries {
+		if e.Link == "" {
+			continue
+		}
+		duration := -1
+		if e.Duration > 0 {
+			duration = e.Duration
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s - %s\n", duration, oneLine(e.Group), oneLine(e.SongName))
+		fmt.Fprintln(bw, oneLine(e.Link))
+	}
+	return bw.Flush()
+}
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version    int         `xml:"version,attr"`
+	Title      string      `xml:"title"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	TrackNum int    `xml:"trackNum"`
+	// Duration is in milliseconds, as XSPF requires.
+	Duration int `xml:"duration,omitempty"`
+}
+
+// writeXSPF writes the playlist as XSPF. Unlike M3U, entries without a link
+// are kept so the track list stays complete.
+func writeXSPF(w io.Writer, p *types.Playlist) error {
+	doc := xspfPlaylist{
+		Version:    1,
+		Title:      p.Name,
+		Annotation: p.Description,
+		Tracks:     make([]xspfTrack, 0, len(p.Entries)),
+	}
+	for _, e := range p.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: e.Link,
+			Title:    e.SongName,
+			Creator:  e.Group,
+			TrackNum: e.Position,
+			Duration: e.Duration * 1000,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// oneLine keeps user-provided text from breaking the line-based M3U format.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
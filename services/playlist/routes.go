@@ -0,0 +1,530 @@
+package playlist
+
+import (
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Handler struct {
+	store types.PlaylistStore
+	logs  *slog.Logger
+}
+
+func NewHandler(playlistStore types.PlaylistStore, env string) *Handler {
+	return &Handler{
+		store: playlistStore,
+		logs:  logger.SetupLogger(env),
+	}
+}
+
+// RegisterRoutes registers the playlist-related routes.
+//
+// @Summary Register playlist routes
+// @Description Adds routes for managing playlists and their entries to the given router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/playlists", h.HandleCreatePlaylist).Methods("POST")
+	router.HandleFunc("/playlists", h.HandleGetPlaylists).Methods("GET")
+	router.HandleFunc("/playlists/{id:[0-9]+}", h.HandleGetPlaylist).Methods("GET")
+	router.HandleFunc("/playlists/{id:[0-9]+}", h.HandleUpdatePlaylist).Methods("PUT")
+	router.HandleFunc("/playlists/{id:[0-9]+}", h.HandleDeletePlaylist).Methods("DELETE")
+	router.HandleFunc("/playlists/{id:[0-9]+}/entries", h.HandleAddEntry).Methods("POST")
+	router.HandleFunc("/playlists/{id:[0-9]+}/entries", h.HandleReorderEntries).Methods("PUT")
+	router.HandleFunc("/playlists/{id:[0-9]+}/entries/{entry:[0-9]+}", h.HandleRemoveEntry).Methods("DELETE")
+	router.HandleFunc("/playlists/{id:[0-9]+}/entries/{entry:[0-9]+}/move", h.HandleMoveEntry).Methods("POST")
+	router.HandleFunc("/playlists/{id:[0-9]+}/export", h.HandleExportPlaylist).Methods("GET")
+}
+
+// HandleCreatePlaylist creates a new playlist.
+//
+// @Summary Create a playlist
+// @Description Creates an empty playlist. duplicates is one of allow, reject (default) or skip and decides what happens when a song already on the playlist is added again.
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param payload body types.PlaylistPayload true "Playlist data"
+// @Success 201 {string} string "Playlist created successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 500 {string} string "Failed to create playlist"
+// @Router /playlists [post]
+func (h *Handler) HandleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleCreatePlaylist"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	payload, ok := h.playlistRequest(w, r, op)
+	if !ok {
+		return
+	}
+	if payload.Duplicates == "" {
+		payload.Duplicates = types.DuplicatesReject
+	}
+
+	id, err := h.store.CreatePlaylist(payload.Name, payload.Description, payload.Duplicates)
+	if err != nil {
+		h.logs.Error("Error creating playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Playlist created successfully", "operation", op, "playlist_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]any{"status": "playlist created", "id": id}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetPlaylists lists playlists.
+//
+// @Summary List playlists
+// @Description Returns a page of playlists with their entry counts and known total durations.
+// @Tags playlists
+// @Produce json
+// @Param offset query int false "Offset for pagination"
+// @Param limit query int false "Maximum number of playlists to return"
+// @Success 200 {object} types.PlaylistPage "Playlists retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to fetch playlists"
+// @Router /playlists [get]
+func (h *Handler) HandleGetPlaylists(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetPlaylists"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	offset, limit, err := httpapi.ParsePage(r.URL.Query(), config.Envs.DefaultPageSize, config.Envs.MaxPageSize)
+	if err != nil {
+		h.logs.Error("Invalid pagination parameters", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	playlists, total, err := h.store.GetPlaylists(offset, limit)
+	if err != nil {
+		h.logs.Error("Error fetching playlists", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	page := types.PlaylistPage{
+		Items:  playlists,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset+limit < total {
+		page.Links.Next = httpapi.PageLink(r, offset+limit, limit)
+	}
+	if offset > 0 {
+		page.Links.Prev = httpapi.PageLink(r, max(offset-limit, 0), limit)
+	}
+
+	h.logs.Debug("Playlists retrieved", "operation", op, "count", len(playlists), "total", total)
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetPlaylist returns a single playlist with its entries.
+//
+// @Summary Retrieve a playlist
+// @Description Returns a playlist with its entries in order.
+// @Tags playlists
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Success 200 {object} types.Playlist "Playlist retrieved successfully"
+// @Failure 404 {string} string "Playlist not found"
+// @Failure 500 {string} string "Failed to fetch playlist"
+// @Router /playlists/{id} [get]
+func (h *Handler) HandleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetPlaylist"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+
+	playlist, err := h.store.GetPlaylist(id)
+	if err != nil {
+		h.logs.Error("Error fetching playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, playlist); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleUpdatePlaylist updates a playlist.
+//
+// @Summary Update a playlist
+// @Description Replaces the name and description of a playlist. The duplicates policy is kept when omitted and only applies to entries added afterwards.
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Param payload body types.PlaylistPayload true "Playlist data"
+// @Success 200 {string} string "Playlist updated successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Playlist not found"
+// @Failure 500 {string} string "Failed to update playlist"
+// @Router /playlists/{id} [put]
+func (h *Handler) HandleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleUpdatePlaylist"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+	payload, ok := h.playlistRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.UpdatePlaylist(id, payload.Name, payload.Description, payload.Duplicates); err != nil {
+		h.logs.Error("Error updating playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Playlist updated successfully", "operation", op, "playlist_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "playlist updated"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDeletePlaylist deletes a playlist.
+//
+// @Summary Delete a playlist
+// @Description Deletes a playlist and its entries; the songs themselves are kept.
+// @Tags playlists
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Success 200 {string} string "Playlist deleted successfully"
+// @Failure 404 {string} string "Playlist not found"
+// @Failure 500 {string} string "Failed to delete playlist"
+// @Router /playlists/{id} [delete]
+func (h *Handler) HandleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDeletePlaylist"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeletePlaylist(id); err != nil {
+		h.logs.Error("Error deleting playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Playlist deleted successfully", "operation", op, "playlist_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "playlist deleted"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleAddEntry adds a song to a playlist.
+//
+// @Summary Add a song to a playlist
+// @Description Inserts a song at a 1-based position, or appends it when position is omitted. A song already on the playlist is added again, skipped or rejected depending on the playlist's duplicates policy.
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Param payload body types.PlaylistEntryPayload true "Song and optional position"
+// @Success 201 {string} string "Entry added successfully"
+// @Success 200 {string} string "Song already on the playlist and skipped"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Playlist or song not found"
+// @Failure 409 {string} string "Song already on the playlist"
+// @Failure 500 {string} string "Failed to add entry"
+// @Router /playlists/{id}/entries [post]
+func (h *Handler) HandleAddEntry(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleAddEntry"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+
+	var payload types.PlaylistEntryPayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.SongID <= 0 || payload.Position < 0 {
+		h.logs.Error("Invalid entry", "operation", op, "payload", payload)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("songId must be positive and position must not be negative"))
+		return
+	}
+
+	entryID, added, err := h.store.AddEntry(id, payload.SongID, payload.Position)
+	if err != nil {
+		h.logs.Error("Error adding entry", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if !added {
+		if err := httpapi.WriteJSON(w, http.StatusOK, map[string]any{"status": "song already on playlist", "id": entryID}); err != nil {
+			h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+		}
+		return
+	}
+
+	h.logs.Info("Entry added successfully", "operation", op, "playlist_id", id, "entry_id", entryID)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]any{"status": "entry added", "id": entryID}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRemoveEntry removes an entry from a playlist.
+//
+// @Summary Remove a playlist entry
+// @Description Removes an entry and closes the gap it leaves.
+// @Tags playlists
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Param entry path int true "ID of the entry"
+// @Success 200 {string} string "Entry removed successfully"
+// @Failure 404 {string} string "Playlist or entry not found"
+// @Failure 500 {string} string "Failed to remove entry"
+// @Router /playlists/{id}/entries/{entry} [delete]
+func (h *Handler) HandleRemoveEntry(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRemoveEntry"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+	entryID, ok := h.pathID(w, r, op, "entry")
+	if !ok {
+		return
+	}
+
+	if err := h.store.RemoveEntry(id, entryID); err != nil {
+		h.logs.Error("Error removing entry", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Entry removed successfully", "operation", op, "playlist_id", id, "entry_id", entryID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "entry removed"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleMoveEntry moves an entry within a playlist.
+//
+// @Summary Move a playlist entry
+// @Description Moves an entry to a 1-based position, shifting the entries in between. Positions past the end move it to the end.
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Param entry path int true "ID of the entry"
+// @Param payload body types.PlaylistMovePayload true "New position"
+// @Success 200 {string} string "Entry moved successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Playlist or entry not found"
+// @Failure 500 {string} string "Failed to move entry"
+// @Router /playlists/{id}/entries/{entry}/move [post]
+func (h *Handler) HandleMoveEntry(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleMoveEntry"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+	entryID, ok := h.pathID(w, r, op, "entry")
+	if !ok {
+		return
+	}
+
+	var payload types.PlaylistMovePayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Position <= 0 {
+		h.logs.Error("Invalid position", "operation", op, "position", payload.Position)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("position must be positive"))
+		return
+	}
+
+	if err := h.store.MoveEntry(id, entryID, payload.Position); err != nil {
+		h.logs.Error("Error moving entry", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Entry moved successfully", "operation", op, "playlist_id", id, "entry_id", entryID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "entry moved"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleReorderEntries puts the entries of a playlist in a new order.
+//
+// @Summary Reorder a playlist
+// @Description Sets the order of the playlist to the given list of entry IDs, which must contain every entry exactly once.
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the playlist"
+// @Param payload body types.PlaylistOrderPayload true "Entry IDs in their new order"
+// @Success 200 {string} string "Playlist reordered successfully"
+// @Failure 400 {string} string "Invalid input or incomplete order"
+// @Failure 404 {string} string "Playlist not found"
+// @Failure 500 {string} string "Failed to reorder playlist"
+// @Router /playlists/{id}/entries [put]
+func (h *Handler) HandleReorderEntries(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleReorderEntries"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+
+	var payload types.PlaylistOrderPayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.ReorderEntries(id, payload.Entries); err != nil {
+		h.logs.Error("Error reordering playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Playlist reordered successfully", "operation", op, "playlist_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "playlist reordered"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleExportPlaylist exports a playlist as M3U or XSPF.
+//
+// @Summary Export a playlist
+// @Description Writes the playlist as an M3U or XSPF file pointing at each song's link.
+// @Tags playlists
+// @Produce audio/x-mpegurl
+// @Produce application/xspf+xml
+// @Param id path int true "ID of the playlist"
+// @Param format query string false "Export format: m3u (default) or xspf"
+// @Success 200 {string} string "Playlist file"
+// @Failure 400 {string} string "Unknown format"
+// @Failure 404 {string} string "Playlist not found"
+// @Failure 500 {string} string "Failed to export playlist"
+// @Router /playlists/{id}/export [get]
+func (h *Handler) HandleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleExportPlaylist"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.pathID(w, r, op, "id")
+	if !ok {
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = FormatM3U
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		h.logs.Error("Unknown export format", "operation", op, "format", format)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for format: must be m3u or xspf"))
+		return
+	}
+
+	playlist, err := h.store.GetPlaylist(id)
+	if err != nil {
+		h.logs.Error("Error fetching playlist", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="playlist-%d.%s"`, id, format))
+	w.WriteHeader(http.StatusOK)
+
+	if format == FormatXSPF {
+		err = writeXSPF(w, playlist)
+	} else {
+		err = writeM3U(w, playlist)
+	}
+	if err != nil {
+		h.logs.Error("Error writing playlist", "operation", op, logger.Err(err))
+		return
+	}
+	h.logs.Debug("Playlist exported", "operation", op, "playlist_id", id, "format", format, "entries_count", len(playlist.Entries))
+}
+
+// playlistRequest reads and validates a playlist payload, writing a 400 when
+// it is invalid.
+func (h *Handler) playlistRequest(w http.ResponseWriter, r *http.Request, op string) (types.PlaylistPayload, bool) {
+	var payload types.PlaylistPayload
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		h.logs.Error("Missing playlist name", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("name must be provided"))
+		return payload, false
+	}
+	switch payload.Duplicates {
+	case "", types.DuplicatesAllow, types.DuplicatesReject, types.DuplicatesSkip:
+	default:
+		h.logs.Error("Invalid duplicates policy", "operation", op, "duplicates", payload.Duplicates)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("duplicates must be allow, reject or skip"))
+		return payload, false
+	}
+	return payload, true
+}
+
+// pathID reads a positive ID from the named path variable, writing a 400
+// when it is invalid.
+func (h *Handler) pathID(w http.ResponseWriter, r *http.Request, op, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid ID", "operation", op, name, mux.Vars(r)[name])
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for %s: must be a positive integer", name))
+		return 0, false
+	}
+	return id, true
+}
+
+// storeErrorStatus maps errors returned by the store to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrPlaylistNotFound), errors.Is(err, types.ErrEntryNotFound), errors.Is(err, types.ErrSongNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrDuplicateEntry):
+		return http.StatusConflict
+	case errors.Is(err, types.ErrInvalidOrder):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
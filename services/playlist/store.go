@@ -0,0 +1,444 @@
+package playlist
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/lib/pq"
+	"log/slog"
+)
+
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func NewStore(db *sql.DB, env string) *Store {
+	log := logger.SetupLogger(env)
+	const op = "playlist.NewStore"
+	log.Debug("Initializing new store", "operation", op)
+	return &Store{db: db, log: log}
+}
+
+// playlistQuery selects a playlist with its entry count and the total of the
+// song durations that are known.
+const playlistQuery = `SELECT p.id, p.name, COALESCE(p.description, ''), p.duplicates, p.createdAt, p.updatedAt,
+                              COUNT(e.id), COALESCE(SUM(s.duration), 0), COUNT(e.id) - COUNT(s.duration)
+                       FROM playlists p
+                       LEFT JOIN (playlist_entries e
+                                  JOIN songs s ON s.id = e.songId AND s.deletedAt IS NULL) ON e.playlistId = p.id`
+
+func scanPlaylist(row interface{ Scan(...any) error }, p *types.Playlist) error {
+	return row.Scan(&p.ID, &p.Name, &p.Description, &p.Duplicates, &p.CreatedAt, &p.UpdatedAt,
+		&p.EntryCount, &p.Duration, &p.UnknownDurations)
+}
+
+func (s *Store) CreatePlaylist(name, description, duplicates string) (int, error) {
+	const op = "playlist.CreatePlaylist"
+	s.log.Info("Creating playlist", "operation", op, "name", name, "duplicates", duplicates)
+
+	var id int
+	query := `INSERT INTO playlists (name, description, duplicates)
+              VALUES ($1, NULLIF($2, ''), $3)
+              RETURNING id`
+	if err := s.db.QueryRow(query, name, description, duplicates).Scan(&id); err != nil {
+		s.log.Error("Error creating playlist", "operation", op, "name", name, logger.Err(err))
+		return 0, err
+	}
+
+	s.log.Info("Playlist created successfully", "operation", op, "id", id)
+	return id, nil
+}
+
+func (s *Store) GetPlaylists(offset, limit int) ([]types.Playlist, int, error) {
+	const op = "playlist.GetPlaylists"
+	s.log.Debug("Fetching playlists", "operation", op, "offset", offset, "limit", limit)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM playlists`).Scan(&total); err != nil {
+		s.log.Error("Error counting playlists", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	query := playlistQuery + `
+              GROUP BY p.id
+              ORDER BY p.id
+              OFFSET $1 LIMIT $2`
+	rows, err := s.db.Query(query, offset, limit)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	playlists := make([]types.Playlist, 0, limit)
+	for rows.Next() {
+		var p types.Playlist
+		if err := scanPlaylist(rows, &p); err != nil {
+			s.log.Error("Error scanning playlist", "operation", op, logger.Err(err))
+			return nil, 0, err
+		}
+		playlists = append(playlists, p)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating playlists", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+
+	s.log.Debug("Fetched playlists", "operation", op, "playlists_count", len(playlists), "total", total)
+	return playlists, total, nil
+}
+
+// GetPlaylist returns a playlist with its entries in playlist order.
+func (s *Store) GetPlaylist(id int) (*types.Playlist, error) {
+	const op = "playlist.GetPlaylist"
+	s.log.Debug("Fetching playlist", "operation", op, "id", id)
+
+	var p types.Playlist
+	err := scanPlaylist(s.db.QueryRow(playlistQuery+` WHERE p.id = $1 GROUP BY p.id`, id), &p)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Playlist not found", "operation", op, "id", id)
+			return nil, types.ErrPlaylistNotFound
+		}
+		s.log.Error("Error fetching playlist", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+
+	query := `SELECT e.id, ROW_NUMBER() OVER (ORDER BY e.position, e.id), s.id, s.songName, g.groupName,
+                     COALESCE(s.link, ''), COALESCE(s.duration, 0), e.addedAt
+              FROM playlist_entries e
+              JOIN songs s ON s.id = e.songId AND s.deletedAt IS NULL
+              JOIN groups g ON s.songGroupId = g.id
+              WHERE e.playlistId = $1
+              ORDER BY e.position, e.id`
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		s.log.Error("Error fetching playlist entries", "operation", op, "id", id, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	p.Entries = make([]types.PlaylistEntry, 0, p.EntryCount)
+	for rows.Next() {
+		var e types.PlaylistEntry
+		if err := rows.Scan(&e.ID, &e.Position, &e.SongID, &e.SongName, &e.Group, &e.Link, &e.Duration, &e.AddedAt); err != nil {
+			s.log.Error("Error scanning playlist entry", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		p.Entries = append(p.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating playlist entries", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Fetched playlist", "operation", op, "id", id, "entries_count", len(p.Entries))
+	return &p, nil
+}
+
+// UpdatePlaylist replaces the name and description of a playlist. An empty
+// duplicates policy keeps the current one; a new policy only applies to
+// entries added afterwards.
+func (s *Store) UpdatePlaylist(id int, name, description, duplicates string) error {
+	const op = "playlist.UpdatePlaylist"
+	s.log.Info("Updating playlist", "operation", op, "id", id, "name", name)
+
+	query := `UPDATE playlists
+              SET name = $2, description = NULLIF($3, ''), duplicates = COALESCE(NULLIF($4, ''), duplicates), updatedAt = NOW()
+              WHERE id = $1`
+	result, err := s.db.Exec(query, id, name, description, duplicates)
+	if err != nil {
+		s.log.Error("Error updating playlist", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("Playlist not found", "operation", op, "id", id)
+		return types.ErrPlaylistNotFound
+	}
+
+	s.log.Info("Playlist updated successfully", "operation", op, "id", id)
+	return nil
+}
+
+func (s *Store) DeletePlaylist(id int) error {
+	const op = "playlist.DeletePlaylist"
+	s.log.Info("Deleting playlist", "operation", op, "id", id)
+
+	result, err := s.db.Exec(`DELETE FROM playlists WHERE id = $1`, id)
+	if err != nil {
+		s.log.Error("Error deleting playlist", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+		return err
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("Playlist not found", "operation", op, "id", id)
+		return types.ErrPlaylistNotFound
+	}
+
+	s.log.Info("Playlist deleted successfully", "operation", op, "id", id)
+	return nil
+}
+
+// AddEntry inserts a song at the given 1-based position, shifting later
+// entries down. A position of 0 or past the end appends. When the song is
+// already on the playlist the playlist's duplicates policy decides: "allow"
+// adds it again, "skip" returns the existing entry with added set to false
+// and "reject" fails with ErrDuplicateEntry.
+func (s *Store) AddEntry(playlistID, songID, position int) (int, bool, error) {
+	const op = "playlist.AddEntry"
+	s.log.Info("Adding playlist entry", "operation", op, "playlistId", playlistID, "songId", songID, "position", position)
+
+	var entryID int
+	added := false
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		duplicates, err := lockPlaylist(tx, playlistID)
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM songs WHERE id = $1 AND deletedAt IS NULL)`, songID).Scan(&exists); err != nil {
+			s.log.Error("Error checking song existence", "operation", op, "songId", songID, logger.Err(err))
+			return err
+		}
+		if !exists {
+			s.log.Warn("Song not found", "operation", op, "songId", songID)
+			return types.ErrSongNotFound
+		}
+
+		if duplicates != types.DuplicatesAllow {
+			query := `SELECT id FROM playlist_entries WHERE playlistId = $1 AND songId = $2 ORDER BY position LIMIT 1`
+			err := tx.QueryRow(query, playlistID, songID).Scan(&entryID)
+			switch {
+			case err == nil && duplicates == types.DuplicatesSkip:
+				s.log.Info("Song already on playlist, skipping", "operation", op, "playlistId", playlistID, "entryId", entryID)
+				return nil
+			case err == nil:
+				s.log.Warn("Song already on playlist", "operation", op, "playlistId", playlistID, "entryId", entryID)
+				return fmt.Errorf("entry %d: %w", entryID, types.ErrDuplicateEntry)
+			case !errors.Is(err, sql.ErrNoRows):
+				s.log.Error("Error checking for duplicate entry", "operation", op, logger.Err(err))
+				return err
+			}
+		}
+
+		count, err := compactEntries(tx, playlistID)
+		if err != nil {
+			s.log.Error("Error compacting positions", "operation", op, logger.Err(err))
+			return err
+		}
+		if position <= 0 || position > count+1 {
+			position = count + 1
+		}
+
+		query := `UPDATE playlist_entries SET position = position + 1 WHERE playlistId = $1 AND position >= $2`
+		if _, err := tx.Exec(query, playlistID, position); err != nil {
+			s.log.Error("Error shifting entries", "operation", op, logger.Err(err))
+			return err
+		}
+
+		query = `INSERT INTO playlist_entries (playlistId, songId, position) VALUES ($1, $2, $3) RETURNING id`
+		if err := tx.QueryRow(query, playlistID, songID, position).Scan(&entryID); err != nil {
+			s.log.Error("Error adding entry", "operation", op, logger.Err(err))
+			return err
+		}
+		added = true
+
+		s.log.Info("Playlist entry added successfully", "operation", op, "playlistId", playlistID, "entryId", entryID, "position", position)
+		return touchPlaylist(tx, playlistID)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return entryID, added, nil
+}
+
+func (s *Store) RemoveEntry(playlistID, entryID int) error {
+	const op = "playlist.RemoveEntry"
+	s.log.Info("Removing playlist entry", "operation", op, "playlistId", playlistID, "entryId", entryID)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		if _, err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`DELETE FROM playlist_entries WHERE id = $1 AND playlistId = $2`, entryID, playlistID)
+		if err != nil {
+			s.log.Error("Error removing entry", "operation", op, logger.Err(err))
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+			return err
+		}
+		if rowsAffected == 0 {
+			s.log.Warn("Entry not found", "operation", op, "playlistId", playlistID, "entryId", entryID)
+			return types.ErrEntryNotFound
+		}
+
+		if _, err := compactEntries(tx, playlistID); err != nil {
+			s.log.Error("Error compacting positions", "operation", op, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Playlist entry removed successfully", "operation", op, "playlistId", playlistID, "entryId", entryID)
+		return touchPlaylist(tx, playlistID)
+	})
+}
+
+// MoveEntry moves an entry to a 1-based position, shifting the entries in
+// between. Positions past the end move the entry to the end.
+func (s *Store) MoveEntry(playlistID, entryID, position int) error {
+	const op = "playlist.MoveEntry"
+	s.log.Info("Moving playlist entry", "operation", op, "playlistId", playlistID, "entryId", entryID, "position", position)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		if _, err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+
+		count, err := compactEntries(tx, playlistID)
+		if err != nil {
+			s.log.Error("Error compacting positions", "operation", op, logger.Err(err))
+			return err
+		}
+
+		var current int
+		err = tx.QueryRow(`SELECT position FROM playlist_entries WHERE id = $1 AND playlistId = $2`, entryID, playlistID).Scan(&current)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.log.Warn("Entry not found", "operation", op, "playlistId", playlistID, "entryId", entryID)
+				return types.ErrEntryNotFound
+			}
+			s.log.Error("Error fetching entry", "operation", op, logger.Err(err))
+			return err
+		}
+
+		position = min(max(position, 1), count)
+		if position == current {
+			return nil
+		}
+
+		var query string
+		if position > current {
+			query = `UPDATE playlist_entries SET position = position - 1
+                     WHERE playlistId = $1 AND position > $2 AND position <= $3`
+		} else {
+			query = `UPDATE playlist_entries SET position = position + 1
+                     WHERE playlistId = $1 AND position >= $3 AND position < $2`
+		}
+		if _, err := tx.Exec(query, playlistID, current, position); err != nil {
+			s.log.Error("Error shifting entries", "operation", op, logger.Err(err))
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE playlist_entries SET position = $1 WHERE id = $2`, position, entryID); err != nil {
+			s.log.Error("Error moving entry", "operation", op, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Playlist entry moved successfully", "operation", op, "playlistId", playlistID, "entryId", entryID, "from", current, "to", position)
+		return touchPlaylist(tx, playlistID)
+	})
+}
+
+// ReorderEntries puts the playlist in the order of entryIDs, which must list
+// every entry of the playlist exactly once.
+func (s *Store) ReorderEntries(playlistID int, entryIDs []int) error {
+	const op = "playlist.ReorderEntries"
+	s.log.Info("Reordering playlist", "operation", op, "playlistId", playlistID, "entries_count", len(entryIDs))
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		if _, err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+
+		var current []int64
+		query := `SELECT COALESCE(array_agg(id), '{}') FROM playlist_entries WHERE playlistId = $1`
+		if err := tx.QueryRow(query, playlistID).Scan(pq.Array(&current)); err != nil {
+			s.log.Error("Error fetching entries", "operation", op, logger.Err(err))
+			return err
+		}
+
+		pending := make(map[int]bool, len(current))
+		for _, id := range current {
+			pending[int(id)] = true
+		}
+		for _, id := range entryIDs {
+			if !pending[id] {
+				s.log.Warn("Unknown or repeated entry in order", "operation", op, "entryId", id)
+				return fmt.Errorf("entry %d: %w", id, types.ErrInvalidOrder)
+			}
+			delete(pending, id)
+		}
+		if len(pending) > 0 {
+			s.log.Warn("Order is missing entries", "operation", op, "missing_count", len(pending))
+			return fmt.Errorf("%d entries missing: %w", len(pending), types.ErrInvalidOrder)
+		}
+
+		query = `UPDATE playlist_entries e SET position = o.ord
+                 FROM unnest($2::int[]) WITH ORDINALITY AS o(id, ord)
+                 WHERE e.id = o.id AND e.playlistId = $1`
+		if _, err := tx.Exec(query, playlistID, pq.Array(entryIDs)); err != nil {
+			s.log.Error("Error reordering entries", "operation", op, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Playlist reordered successfully", "operation", op, "playlistId", playlistID)
+		return touchPlaylist(tx, playlistID)
+	})
+}
+
+// lockPlaylist locks the playlist row for the rest of the transaction so
+// concurrent edits of its entries are serialized, and returns its duplicates
+// policy.
+func lockPlaylist(tx *sql.Tx, id int) (string, error) {
+	var duplicates string
+	err := tx.QueryRow(`SELECT duplicates FROM playlists WHERE id = $1 FOR UPDATE`, id).Scan(&duplicates)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", types.ErrPlaylistNotFound
+	}
+	return duplicates, err
+}
+
+// compactEntries renumbers the entries of a playlist to 1..n, closing gaps
+// left by removed entries and songs, and returns n.
+func compactEntries(tx *sql.Tx, playlistID int) (int, error) {
+	query := `UPDATE playlist_entries e SET position = o.rn
+              FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY position, id) AS rn
+                    FROM playlist_entries WHERE playlistId = $1) o
+              WHERE e.id = o.id AND e.position <> o.rn`
+	if _, err := tx.Exec(query, playlistID); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM playlist_entries WHERE playlistId = $1`, playlistID).Scan(&count)
+	return count, err
+}
+
+func touchPlaylist(tx *sql.Tx, id int) error {
+	_, err := tx.Exec(`UPDATE playlists SET updatedAt = NOW() WHERE id = $1`, id)
+	return err
+}
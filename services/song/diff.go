@@ -0,0 +1,88 @@
+package song
+
+import (
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"slices"
+)
+
+// diffSnapshots compares two states of a song field by field and verse by
+// verse.
+func diffSnapshots(from, to *types.SongSnapshot) ([]types.FieldChange, []types.VerseChange) {
+	return diffFields(from, to), diffVerses(from.SongLyrics, to.SongLyrics)
+}
+
+// diffFields lists the fields that differ between two snapshots, in the
+// order they appear in a song.
+func diffFields(from, to *types.SongSnapshot) []types.FieldChange {
+	changes := []types.FieldChange{}
+	if from.SongName != to.SongName {
+		changes = append(changes, types.FieldChange{Field: "song", From: from.SongName, To: to.SongName})
+	}
+	if from.Group != to.Group {
+		changes = append(changes, types.FieldChange{Field: "group", From: from.Group, To: to.Group})
+	}
+	if !slices.Equal(from.Artists, to.Artists) {
+		changes = append(changes, types.FieldChange{Field: "artists", From: from.Artists, To: to.Artists})
+	}
+	if !slices.Equal(from.SongLyrics, to.SongLyrics) {
+		changes = append(changes, types.FieldChange{Field: "songLyrics"})
+	}
+	if from.Published != to.Published {
+		changes = append(changes, types.FieldChange{Field: "published", From: from.Published, To: to.Published})
+	}
+	if from.Link != to.Link {
+		changes = append(changes, types.FieldChange{Field: "link", From: from.Link, To: to.Link})
+	}
+	return changes
+}
+
+// diffVerses returns the verses removed from and added to the lyrics, based
+// on their longest common subsequence. Verses that only moved show up as
+// removed at the old position and added at the new one.
+func diffVerses(from, to []string) []types.VerseChange {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:]
+	// and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	changes := []types.VerseChange{}
+	removed := func(i int) {
+		changes = append(changes, types.VerseChange{Op: types.VerseRemoved, OldIndex: &i, Text: from[i]})
+	}
+	added := func(j int) {
+		changes = append(changes, types.VerseChange{Op: types.VerseAdded, NewIndex: &j, Text: to[j]})
+	}
+
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed(i)
+			i++
+		default:
+			added(j)
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		removed(i)
+	}
+	for ; j < len(to); j++ {
+		added(j)
+	}
+	return changes
+}
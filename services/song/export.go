@@ -0,0 +1,203 @@
+package song
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FormatJSON writes an export as a single JSON array. Exports are also
+// written as FormatCSV and FormatNDJSON.
+const FormatJSON = "json"
+
+// exportContentTypes maps the export formats to the content type they are
+// served with.
+var exportContentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+	FormatJSON:   "application/json",
+}
+
+// exportColumns are the columns of a CSV export. Lyrics are one cell with
+// verses separated by blank lines, the way imports read them; artists, genres
+// and tags are separated by semicolons.
+var exportColumns = []string{"id", "song", "group", "lyrics", "published", "link", "version", "artists", "album", "track", "genres", "tags"}
+
+// exportWriter writes the songs of an export in one format. Nothing reaches
+// the underlying writer before the first song or close, so an export that
+// fails right away can still be answered with an error.
+type exportWriter interface {
+	write(song *types.ExportedSong) error
+	close() error
+}
+
+// Export writes every song matching the GetSongs filters to w in the given
+// format and returns the number of songs written. Songs are streamed from the
+// store one at a time. When the error is returned after songs were written,
+// the output is incomplete.
+func Export(ctx context.Context, store types.SongStore, w io.Writer, format string, filters url.Values) (int, error) {
+	var out exportWriter
+	switch format {
+	case FormatCSV:
+		out = &csvExportWriter{w: csv.NewWriter(w)}
+	case FormatNDJSON:
+		out = &ndjsonExportWriter{encoder: json.NewEncoder(w)}
+	case FormatJSON:
+		out = &jsonExportWriter{w: w}
+	default:
+		return 0, fmt.Errorf("%w: invalid value for 'format': must be %s, %s or %s", types.ErrInvalidFilter, FormatCSV, FormatNDJSON, FormatJSON)
+	}
+
+	count := 0
+	err := store.ExportSongs(ctx, filters, func(song *types.Song) error {
+		if err := out.write(exportedSong(song)); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, out.close()
+}
+
+func exportedSong(song *types.Song) *types.ExportedSong {
+	exported := &types.ExportedSong{
+		ID:       song.ID,
+		SongName: song.SongName,
+		Group:    song.Group,
+		Lyrics:   song.SongLyrics,
+		Link:     song.Link,
+		Version:  song.Version,
+		Artists:  song.Artists,
+		Album:    song.Album,
+		Genres:   song.Genres,
+		Tags:     song.Tags,
+	}
+	if song.Published != nil {
+		exported.Published = song.Published.Format(types.DateLayout)
+	}
+	// Lists are always present, so every row has the same shape
+	if exported.Lyrics == nil {
+		exported.Lyrics = []string{}
+	}
+	if exported.Artists == nil {
+		exported.Artists = []types.SongArtist{}
+	}
+	if exported.Genres == nil {
+		exported.Genres = []string{}
+	}
+	if exported.Tags == nil {
+		exported.Tags = []string{}
+	}
+	return exported
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func (c *csvExportWriter) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	return c.w.Write(exportColumns)
+}
+
+func (c *csvExportWriter) write(song *types.ExportedSong) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	artists := make([]string, len(song.Artists))
+	for i, artist := range song.Artists {
+		artists[i] = fmt.Sprintf("%s (%s)", artist.Name, artist.Role)
+	}
+	album, track := "", ""
+	if song.Album != nil {
+		album = song.Album.Title
+		if song.Album.TrackNumber > 0 {
+			track = strconv.Itoa(song.Album.TrackNumber)
+		}
+	}
+	record := []string{
+		strconv.Itoa(song.ID),
+		song.SongName,
+		song.Group,
+		strings.Join(song.Lyrics, "\n\n"),
+		song.Published,
+		song.Link,
+		strconv.Itoa(song.Version),
+		strings.Join(artists, "; "),
+		album,
+		track,
+		strings.Join(song.Genres, "; "),
+		strings.Join(song.Tags, "; "),
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	// Flush regularly so the export streams instead of piling up in the buffer
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvExportWriter) close() error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonExportWriter writes one JSON object per line, lyrics as a list of
+// verses.
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonExportWriter) write(song *types.ExportedSong) error {
+	return n.encoder.Encode(song)
+}
+
+func (n *ndjsonExportWriter) close() error {
+	return nil
+}
+
+// jsonExportWriter writes a JSON array with one song per line, lyrics as a
+// list of verses.
+type jsonExportWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (j *jsonExportWriter) write(song *types.ExportedSong) error {
+	data, err := json.Marshal(song)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if !j.started {
+		separator = "[\n"
+		j.started = true
+	}
+	_, err = io.WriteString(j.w, separator+string(data))
+	return err
+}
+
+func (j *jsonExportWriter) close() error {
+	end := "\n]\n"
+	if !j.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
@@ -0,0 +1,400 @@
+package song
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/normalize"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/go-resty/resty/v2"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// File formats accepted by Importer.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// importColumns are the columns of an import file. song and group are
+// required; lyrics, published and link are filled in from the external API
+// when missing.
+var importColumns = []string{"song", "group", "lyrics", "published", "link"}
+
+// maxNameLength is the length of the name, group and link columns.
+const maxNameLength = 255
+
+// maxNDJSONLine bounds a single NDJSON row, lyrics included.
+const maxNDJSONLine = 1 << 20
+
+// importRow is a validated row of an import file.
+type importRow struct {
+	line      int
+	songName  string
+	group     string
+	lyrics    []string
+	published string
+	link      string
+}
+
+// needsDetails reports whether the external API has to complete the row.
+func (r *importRow) needsDetails() bool {
+	return len(r.lyrics) == 0 || r.published == "" || r.link == ""
+}
+
+// Importer creates songs in bulk from CSV or NDJSON files. Rows missing
+// lyrics, a date or a link are completed from the external API with at most
+// concurrency requests in flight.
+type Importer struct {
+	store       types.SongStore
+	apiClient   *resty.Client
+	externalAPI string
+	concurrency int
+	maxRows     int
+	logs        *slog.Logger
+}
+
+func NewImporter(songStore types.SongStore, concurrency int, env string) *Importer {
+	return &Importer{
+		store:       songStore,
+		apiClient:   resty.New(),
+		externalAPI: config.Envs.ExtApi,
+		concurrency: max(concurrency, 1),
+		maxRows:     config.Envs.ImportMaxRows,
+		logs:        logger.SetupLogger(env),
+	}
+}
+
+// Import reads a whole file and creates a song for every valid row that
+// isn't in the library yet. A dry run validates the rows and checks for
+// duplicates without calling the external API or writing anything. Rows are
+// reported in file order; an error wrapping types.ErrInvalidImport is only
+// returned when the file as a whole can't be read.
+func (im *Importer) Import(ctx context.Context, r io.Reader, format string, dryRun bool, actor string) (*types.ImportReport, error) {
+	const op = "song.Import"
+	im.logs.Info("Starting import", "operation", op, "format", format, "dry_run", dryRun)
+
+	var results []types.ImportRowResult
+	var rows []*importRow
+	var err error
+	switch format {
+	case FormatCSV:
+		results, rows, err = im.readCSV(r)
+	case FormatNDJSON:
+		results, rows, err = im.readNDJSON(r)
+	default:
+		err = fmt.Errorf("%w: format must be %s or %s", types.ErrInvalidImport, FormatCSV, FormatNDJSON)
+	}
+	if err != nil {
+		im.logs.Error("Error reading import file", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	// Later copies of a song within the file are skipped up front, so
+	// concurrent workers never race to create the same song
+	seen := make(map[string]int)
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		key := normalize.GroupKey(row.group, config.Envs.GroupStripThe) + "\x00" + normalize.SongKey(row.songName)
+		if line, ok := seen[key]; ok {
+			results[i].Status = types.ImportSkipped
+			results[i].Message = fmt.Sprintf("duplicate of line %d", line)
+			rows[i] = nil
+			continue
+		}
+		seen[key] = row.line
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range im.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				im.importRow(ctx, rows[i], &results[i], dryRun, actor)
+			}
+		}()
+	}
+	for i, row := range rows {
+		if row != nil {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := &types.ImportReport{DryRun: dryRun, Total: len(results), Rows: results}
+	for _, result := range results {
+		switch result.Status {
+		case types.ImportCreated:
+			report.Created++
+		case types.ImportValid:
+			report.Valid++
+		case types.ImportSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+
+	im.logs.Info("Import finished", "operation", op, "dry_run", dryRun, "total", report.Total,
+		"created", report.Created, "valid", report.Valid, "skipped", report.Skipped, "failed", report.Failed)
+	return report, nil
+}
+
+// importRow creates the song of a single row and records the outcome.
+func (im *Importer) importRow(ctx context.Context, row *importRow, result *types.ImportRowResult, dryRun bool, actor string) {
+	const op = "song.importRow"
+
+	fail := func(err error) {
+		result.Status = types.ImportFailed
+		result.Message = err.Error()
+	}
+	if err := ctx.Err(); err != nil {
+		fail(fmt.Errorf("import cancelled: %w", err))
+		return
+	}
+
+	existingID, err := im.store.FindSong(row.songName, row.group)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if existingID > 0 {
+		result.Status = types.ImportSkipped
+		result.ID = existingID
+		result.Message = "song already exists"
+		return
+	}
+	if dryRun {
+		result.Status = types.ImportValid
+		return
+	}
+
+	details := &types.SongDetail{ReleaseDate: row.published, Link: row.link}
+	lyrics := row.lyrics
+	if row.needsDetails() {
+		fetched, err := fetchSongDetails(ctx, im.apiClient, im.logs, row.group, row.songName, im.externalAPI)
+		if err != nil {
+			fail(fmt.Errorf("could not fetch song details: %w", err))
+			return
+		}
+		if len(lyrics) == 0 {
+			lyrics = splitLyrics(fetched.Text)
+		}
+		if details.ReleaseDate == "" && fetched.ReleaseDate != "" {
+			if published, err := parseReleaseDate(fetched.ReleaseDate); err == nil {
+				details.ReleaseDate = published.Format(types.DateLayout)
+			} else {
+				im.logs.Warn("Ignoring unparsable release date", "operation", op, "line", row.line, "release_date", fetched.ReleaseDate)
+			}
+		}
+		if details.Link == "" {
+			details.Link = fetched.Link
+		}
+		details.Duration = fetched.Duration
+	}
+
+	id, err := im.store.AddSong(row.songName, row.group, nil, details, lyrics, actor)
+	var duplicate *types.DuplicateSongError
+	if errors.As(err, &duplicate) {
+		result.Status = types.ImportSkipped
+		result.ID = duplicate.ExistingID
+		result.Message = "song already exists"
+		return
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+	result.Status = types.ImportCreated
+	result.ID = id
+}
+
+// newImportRow validates the fields of a row.
+func newImportRow(line int, songName, group string, lyrics []string, published, link string) (*importRow, error) {
+	row := &importRow{
+		line:     line,
+		songName: strings.TrimSpace(songName),
+		group:    strings.TrimSpace(group),
+		lyrics:   lyrics,
+		link:     strings.TrimSpace(link),
+	}
+	if row.songName == "" {
+		return nil, errors.New("song must not be empty")
+	}
+	if row.group == "" {
+		return nil, errors.New("group must not be empty")
+	}
+	for _, field := range []struct{ name, value string }{{"song", row.songName}, {"group", row.group}, {"link", row.link}} {
+		if utf8.RuneCountInString(field.value) > maxNameLength {
+			return nil, fmt.Errorf("%s is longer than %d characters", field.name, maxNameLength)
+		}
+	}
+	if published = strings.TrimSpace(published); published != "" {
+		date, err := parseReleaseDate(published)
+		if err != nil {
+			return nil, fmt.Errorf("invalid published date '%s': must be YYYY-MM-DD or DD.MM.YYYY", published)
+		}
+		row.published = date.Format(types.DateLayout)
+	}
+	return row, nil
+}
+
+// readCSV reads a CSV file whose header names its columns. Lyrics are a
+// single cell with verses separated by blank lines.
+func (im *Importer) readCSV(r io.Reader) ([]types.ImportRowResult, []*importRow, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("%w: file is empty", types.ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", types.ErrInvalidImport, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(importColumns, name) {
+			return nil, nil, fmt.Errorf("%w: unknown column '%s', expected %s", types.ErrInvalidImport, name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, nil, fmt.Errorf("%w: duplicate column '%s'", types.ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range importColumns[:2] {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("%w: missing required column '%s'", types.ErrInvalidImport, name)
+		}
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	var results []types.ImportRowResult
+	var rows []*importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if len(results) == im.maxRows {
+			return nil, nil, fmt.Errorf("%w: more than %d rows", types.ErrInvalidImport, im.maxRows)
+		}
+
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, nil, fmt.Errorf("%w: %w", types.ErrInvalidImport, err)
+		}
+		if parseErr != nil {
+			results = append(results, types.ImportRowResult{Line: parseErr.StartLine, Status: types.ImportFailed, Message: parseErr.Err.Error()})
+			rows = append(rows, nil)
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		lyrics := splitLyrics(strings.ReplaceAll(cell(record, "lyrics"), "\r\n", "\n"))
+		result := types.ImportRowResult{Line: line, SongName: cell(record, "song"), Group: cell(record, "group")}
+		row, err := newImportRow(line, cell(record, "song"), cell(record, "group"), lyrics, cell(record, "published"), cell(record, "link"))
+		if err != nil {
+			result.Status = types.ImportFailed
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+		rows = append(rows, row)
+	}
+	return results, rows, nil
+}
+
+// ndjsonRow is a row of an NDJSON file. Lyrics are either a list of verses
+// or a single string with verses separated by blank lines.
+type ndjsonRow struct {
+	SongName  string          `json:"song"`
+	Group     string          `json:"group"`
+	Lyrics    json.RawMessage `json:"lyrics"`
+	Published string          `json:"published"`
+	Link      string          `json:"link"`
+}
+
+// readNDJSON reads a file with one JSON object per line. Blank lines are
+// ignored.
+func (im *Importer) readNDJSON(r io.Reader) ([]types.ImportRowResult, []*importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+
+	var results []types.ImportRowResult
+	var rows []*importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(results) == im.maxRows {
+			return nil, nil, fmt.Errorf("%w: more than %d rows", types.ErrInvalidImport, im.maxRows)
+		}
+
+		result := types.ImportRowResult{Line: line}
+		row, err := parseNDJSONRow(line, data, &result)
+		if err != nil {
+			result.Status = types.ImportFailed
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, fmt.Errorf("%w: line %d is longer than %d bytes", types.ErrInvalidImport, line+1, maxNDJSONLine)
+		}
+		return nil, nil, fmt.Errorf("%w: %w", types.ErrInvalidImport, err)
+	}
+	if len(results) == 0 {
+		return nil, nil, fmt.Errorf("%w: file is empty", types.ErrInvalidImport)
+	}
+	return results, rows, nil
+}
+
+func parseNDJSONRow(line int, data []byte, result *types.ImportRowResult) (*importRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var raw ndjsonRow
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	result.SongName = raw.SongName
+	result.Group = raw.Group
+
+	var lyrics []string
+	if len(raw.Lyrics) > 0 && string(raw.Lyrics) != "null" {
+		if err := json.Unmarshal(raw.Lyrics, &lyrics); err != nil {
+			var text string
+			if err := json.Unmarshal(raw.Lyrics, &text); err != nil {
+				return nil, errors.New("lyrics must be a string or a list of verses")
+			}
+			lyrics = splitLyrics(text)
+		}
+	}
+	return newImportRow(line, raw.SongName, raw.Group, lyrics, raw.Published, raw.Link)
+}
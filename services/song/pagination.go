@@ -0,0 +1,203 @@
+package song
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sortKey is one column of a result ordering. value extracts the key from a
+// scanned song so the last row of a page can be encoded into a cursor.
+type sortKey struct {
+	name  string
+	expr  string
+	desc  bool
+	value func(song *types.Song) string
+}
+
+var idKey = sortKey{
+	name:  "id",
+	expr:  "s.id",
+	value: func(song *types.Song) string { return strconv.Itoa(song.ID) },
+}
+
+// sortableKeys is the allowlist of keys accepted by the `sort` parameter.
+var sortableKeys = map[string]sortKey{
+	"id": idKey,
+	"song": {
+		name:  "song",
+		expr:  "s.songName",
+		value: func(song *types.Song) string { return song.SongName },
+	},
+	"group": {
+		name:  "group",
+		expr:  "g.groupName",
+		value: func(song *types.Song) string { return song.Group },
+	},
+	// Songs without a date sort as the earliest ones; a NULL would never
+	// match the keyset condition
+	"published": {
+		name: "published",
+		expr: "COALESCE(s.published, '0001-01-01')",
+		value: func(song *types.Song) string {
+			if song.Published == nil {
+				return time.Time{}.Format(time.RFC3339Nano)
+			}
+			return song.Published.Format(time.RFC3339Nano)
+		},
+	},
+}
+
+// parseSort turns a value such as "-published,group,song" into sort keys.
+// A leading "-" sorts descending. Computed keys such as rank and score are
+// only allowed when the query produces them, and s.id is always appended as
+// a tie-breaker.
+func parseSort(value string, computed ...sortKey) ([]sortKey, error) {
+	allowed := make(map[string]sortKey, len(sortableKeys)+len(computed))
+	for name, k := range sortableKeys {
+		allowed[name] = k
+	}
+	for _, k := range computed {
+		allowed[k.name] = k
+	}
+
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		name := strings.TrimPrefix(field, "-")
+
+		k, ok := allowed[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown sort key '%s', allowed keys: %s", types.ErrInvalidFilter, name, allowedSortKeys(allowed))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: sort key '%s' is repeated", types.ErrInvalidFilter, name)
+		}
+		seen[name] = true
+
+		k.desc = desc
+		keys = append(keys, k)
+	}
+
+	if !seen[idKey.name] {
+		keys = append(keys, idKey)
+	}
+	return keys, nil
+}
+
+func allowedSortKeys(allowed map[string]sortKey) string {
+	names := make([]string, 0, len(allowed))
+	for name := range allowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func rankKey(expr string) sortKey {
+	return sortKey{
+		name:  "rank",
+		expr:  expr,
+		desc:  true,
+		value: func(song *types.Song) string { return strconv.FormatFloat(song.Rank, 'g', -1, 64) },
+	}
+}
+
+func scoreKey(expr string) sortKey {
+	return sortKey{
+		name:  "score",
+		expr:  expr,
+		desc:  true,
+		value: func(song *types.Song) string { return strconv.FormatFloat(song.Score, 'g', -1, 64) },
+	}
+}
+
+// cursor is the decoded form of the opaque `after` parameter. Sort records
+// the ordering it was issued for, so it can't be replayed against another.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// orderSignature describes an ordering, e.g. "-rank,id".
+func orderSignature(keys []sortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		if k.desc {
+			parts[i] = "-" + k.name
+		} else {
+			parts[i] = k.name
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func orderByClause(keys []sortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		if k.desc {
+			parts[i] = k.expr + " DESC"
+		} else {
+			parts[i] = k.expr + " ASC"
+		}
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+func encodeCursor(keys []sortKey, song *types.Song) string {
+	c := cursor{Sort: orderSignature(keys), Values: make([]string, len(keys))}
+	for i, k := range keys {
+		c.Values[i] = k.value(song)
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(keys []sortKey, encoded string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor in 'after'", types.ErrInvalidFilter)
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor in 'after'", types.ErrInvalidFilter)
+	}
+	if c.Sort != orderSignature(keys) || len(c.Values) != len(keys) {
+		return nil, fmt.Errorf("%w: cursor in 'after' was issued for a different ordering", types.ErrInvalidFilter)
+	}
+	return &c, nil
+}
+
+// keysetCondition builds the WHERE clause selecting rows strictly after the
+// cursor position: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(keys []sortKey, c *cursor, argIndex int) (string, []interface{}) {
+	var args []interface{}
+	placeholders := make([]string, len(keys))
+	for i, v := range c.Values {
+		placeholders[i] = fmt.Sprintf("$%d", argIndex)
+		args = append(args, v)
+		argIndex++
+	}
+
+	var branches []string
+	for i, k := range keys {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", keys[j].expr, placeholders[j]))
+		}
+		op := ">"
+		if k.desc {
+			op = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", k.expr, op, placeholders[i]))
+		branches = append(branches, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", args
+}
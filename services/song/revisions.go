@@ -0,0 +1,265 @@
+package song
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/db"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/normalize"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/lib/pq"
+)
+
+// snapshotQuery builds the types.SongSnapshot of a song as JSON. The
+// song_revisions migration backfills existing songs with the same shape.
+const snapshotQuery = `SELECT json_build_object(
+                           'song', s.songName,
+                           'group', g.groupName,
+                           'artists', COALESCE((SELECT json_agg(json_build_object('name', ag.groupName, 'role', sa.role) ORDER BY sa.position)
+                                                FROM song_artists sa JOIN groups ag ON sa.groupId = ag.id
+                                                WHERE sa.songId = s.id), '[]'::json),
+                           'songLyrics', COALESCE(to_json(s.songLyrics), '[]'::json),
+                           'published', COALESCE(to_char(s.published, 'YYYY-MM-DD'), ''),
+                           'link', COALESCE(s.link, ''))
+                       FROM songs s
+                       JOIN groups g ON s.songGroupId = g.id
+                       WHERE s.id = $1`
+
+// recordRevision stores the current state of a song as its next revision,
+// listing the fields that changed since the previous one. Nothing is stored
+// when nothing changed. It returns the latest revision number and must run in
+// the transaction that changed the song, after the song row was locked.
+func (s *Store) recordRevision(tx *sql.Tx, songID int, action, actor string, revertedFrom int) (int, error) {
+	const op = "song.recordRevision"
+
+	var raw []byte
+	if err := tx.QueryRow(snapshotQuery, songID).Scan(&raw); err != nil {
+		s.log.Error("Error building song snapshot", "operation", op, "songId", songID, logger.Err(err))
+		return 0, err
+	}
+	var current types.SongSnapshot
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return 0, err
+	}
+
+	changes := []string{}
+	var latest int
+	var previousRaw []byte
+	query := `SELECT revision, snapshot FROM song_revisions WHERE songId = $1 ORDER BY revision DESC LIMIT 1`
+	err := tx.QueryRow(query, songID).Scan(&latest, &previousRaw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		s.log.Error("Error fetching latest revision", "operation", op, "songId", songID, logger.Err(err))
+		return 0, err
+	default:
+		var previous types.SongSnapshot
+		if err := json.Unmarshal(previousRaw, &previous); err != nil {
+			return 0, err
+		}
+		for _, change := range diffFields(&previous, &current) {
+			changes = append(changes, change.Field)
+		}
+		if len(changes) == 0 {
+			s.log.Debug("Song unchanged, no revision recorded", "operation", op, "songId", songID)
+			return latest, nil
+		}
+	}
+
+	query = `INSERT INTO song_revisions (songId, revision, action, changedBy, changes, revertedFrom, snapshot)
+             VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, 0), $7)`
+	if _, err := tx.Exec(query, songID, latest+1, action, actor, pq.Array(changes), revertedFrom, string(raw)); err != nil {
+		s.log.Error("Error recording revision", "operation", op, "songId", songID, logger.Err(err))
+		return 0, err
+	}
+
+	s.log.Info("Song revision recorded", "operation", op, "songId", songID, "revision", latest+1, "action", action, "changes", changes)
+	return latest + 1, nil
+}
+
+// GetSongRevisions lists the revisions of a song, newest first, without
+// their snapshots.
+func (s *Store) GetSongRevisions(songID int) ([]types.SongRevision, error) {
+	const op = "song.GetSongRevisions"
+	s.log.Debug("Fetching song revisions", "operation", op, "songId", songID)
+
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM songs WHERE id = $1 AND deletedAt IS NULL)`, songID).Scan(&exists); err != nil {
+		s.log.Error("Error checking song existence", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	if !exists {
+		return nil, types.ErrSongNotFound
+	}
+
+	query := `SELECT revision, action, changedBy, changedAt, changes, revertedFrom
+              FROM song_revisions
+              WHERE songId = $1
+              ORDER BY revision DESC`
+	rows, err := s.db.Query(query, songID)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	revisions := []types.SongRevision{}
+	for rows.Next() {
+		revision := types.SongRevision{SongID: songID}
+		if err := scanRevision(rows, &revision); err != nil {
+			s.log.Error("Error scanning revision", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating revisions", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Fetched song revisions", "operation", op, "songId", songID, "revisions_count", len(revisions))
+	return revisions, nil
+}
+
+// GetSongRevision returns a single revision of a song with its snapshot.
+func (s *Store) GetSongRevision(songID, revision int) (*types.SongRevision, error) {
+	const op = "song.GetSongRevision"
+	s.log.Debug("Fetching song revision", "operation", op, "songId", songID, "revision", revision)
+
+	result := types.SongRevision{SongID: songID}
+	var raw []byte
+	query := `SELECT r.revision, r.action, r.changedBy, r.changedAt, r.changes, r.revertedFrom, r.snapshot
+              FROM songs s
+              LEFT JOIN song_revisions r ON r.songId = s.id AND r.revision = $2
+              WHERE s.id = $1 AND s.deletedAt IS NULL`
+	var (
+		rev          sql.NullInt64
+		action       sql.NullString
+		changedBy    sql.NullString
+		changedAt    sql.NullTime
+		revertedFrom sql.NullInt64
+	)
+	err := s.db.QueryRow(query, songID, revision).Scan(&rev, &action, &changedBy, &changedAt, pq.Array(&result.Changes), &revertedFrom, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.log.Warn("Song not found", "operation", op, "songId", songID)
+		return nil, types.ErrSongNotFound
+	}
+	if err != nil {
+		s.log.Error("Error fetching revision", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	if !rev.Valid {
+		s.log.Warn("Revision not found", "operation", op, "songId", songID, "revision", revision)
+		return nil, fmt.Errorf("revision %d of song %d: %w", revision, songID, types.ErrRevisionNotFound)
+	}
+
+	result.Revision = int(rev.Int64)
+	result.Action = action.String
+	result.ChangedBy = changedBy.String
+	result.ChangedAt = changedAt.Time
+	result.RevertedFrom = int(revertedFrom.Int64)
+	result.Snapshot = &types.SongSnapshot{}
+	if err := json.Unmarshal(raw, result.Snapshot); err != nil {
+		s.log.Error("Error decoding snapshot", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	return &result, nil
+}
+
+func scanRevision(row interface{ Scan(...any) error }, revision *types.SongRevision) error {
+	var changedBy sql.NullString
+	var revertedFrom sql.NullInt64
+	err := row.Scan(&revision.Revision, &revision.Action, &changedBy, &revision.ChangedAt, pq.Array(&revision.Changes), &revertedFrom)
+	if err != nil {
+		return err
+	}
+	revision.ChangedBy = changedBy.String
+	revision.RevertedFrom = int(revertedFrom.Int64)
+	return nil
+}
+
+// RevertSong restores the song to the state stored with a revision and
+// records that as a new revision, whose number it returns. History is never
+// rewritten, so a revert can itself be reverted.
+func (s *Store) RevertSong(songID, revision int, actor string) (int, error) {
+	const op = "song.RevertSong"
+	s.log.Info("Reverting song", "operation", op, "songId", songID, "revision", revision, "actor", actor)
+
+	var latest int
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		var locked int
+		err := tx.QueryRow(`SELECT id FROM songs WHERE id = $1 AND deletedAt IS NULL FOR UPDATE`, songID).Scan(&locked)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Song not found", "operation", op, "songId", songID)
+			return fmt.Errorf("song with ID %d: %w", songID, types.ErrSongNotFound)
+		}
+		if err != nil {
+			s.log.Error("Error locking song", "operation", op, logger.Err(err))
+			return err
+		}
+
+		var raw []byte
+		err = tx.QueryRow(`SELECT snapshot FROM song_revisions WHERE songId = $1 AND revision = $2`, songID, revision).Scan(&raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Revision not found", "operation", op, "songId", songID, "revision", revision)
+			return fmt.Errorf("revision %d of song %d: %w", revision, songID, types.ErrRevisionNotFound)
+		}
+		if err != nil {
+			s.log.Error("Error fetching revision", "operation", op, logger.Err(err))
+			return err
+		}
+		var snapshot types.SongSnapshot
+		if err := json.Unmarshal(raw, &snapshot); err != nil {
+			s.log.Error("Error decoding snapshot", "operation", op, logger.Err(err))
+			return err
+		}
+
+		groupID, err := s.upsertGroup(tx, snapshot.Group)
+		if err != nil {
+			s.log.Error("Error resolving group", "operation", op, "group", snapshot.Group, logger.Err(err))
+			return fmt.Errorf("could not resolve group '%s': %w", snapshot.Group, err)
+		}
+
+		query := `UPDATE songs
+                  SET songName = $1, songKey = $2, songGroupId = $3, songLyrics = $4,
+                      published = NULLIF($5, '')::timestamp, link = $6, version = version + 1
+                  WHERE id = $7`
+		_, err = tx.Exec(query, snapshot.SongName, normalize.SongKey(snapshot.SongName), groupID,
+			pq.Array(snapshot.SongLyrics), snapshot.Published, snapshot.Link, songID)
+		if err != nil {
+			if db.IsUniqueViolation(err) {
+				s.log.Warn("Revert would duplicate a song", "operation", op, "songId", songID)
+				return types.ErrDuplicateSong
+			}
+			s.log.Error("Error reverting song", "operation", op, logger.Err(err))
+			return err
+		}
+
+		// The first artist of a snapshot is the main group itself
+		var extra []types.SongArtist
+		if len(snapshot.Artists) > 1 {
+			extra = snapshot.Artists[1:]
+		}
+		refs, err := s.resolveArtists(tx, extra)
+		if err != nil {
+			s.log.Error("Error resolving artists", "operation", op, logger.Err(err))
+			return err
+		}
+		if err := s.setSongArtists(tx, songID, groupID, refs); err != nil {
+			return err
+		}
+
+		latest, err = s.recordRevision(tx, songID, types.RevisionRevert, actor, revision)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.log.Info("Song reverted successfully", "operation", op, "songId", songID, "revision", revision, "latest", latest)
+	return latest, nil
+}
@@ -1,16 +1,19 @@
 package song
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
 	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/services/auth"
 	"github.com/genryusaishigikuni/muse_lib/types"
 	"github.com/go-resty/resty/v2"
 	"github.com/gorilla/mux"
-	"log"
 	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -21,6 +24,7 @@ import (
 type Handler struct {
 	store     types.SongStore
 	apiClient *resty.Client
+	importer  *Importer
 	logs      *slog.Logger
 }
 
@@ -28,6 +32,7 @@ func NewHandler(songStore types.SongStore, env string) *Handler {
 	return &Handler{
 		store:     songStore,
 		apiClient: resty.New(),
+		importer:  NewImporter(songStore, config.Envs.ImportConcurrency, env),
 		logs:      logger.SetupLogger(env),
 	}
 }
@@ -38,27 +43,55 @@ func NewHandler(songStore types.SongStore, env string) *Handler {
 // @Description Adds routes for managing songs to the given router.
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/songs/add", h.HandleAddSong).Methods("POST")
+	router.HandleFunc("/songs/import", h.HandleImportSongs).Methods("POST")
 	router.HandleFunc("/songs/get", h.HandleGetSong).Methods("GET")
+	router.HandleFunc("/songs/export", h.HandleExportSongs).Methods("GET")
 	router.HandleFunc("/songs/update", h.HandleUpdateSong).Methods("PUT")
 	router.HandleFunc("/songs/delete", h.HandleDeleteSong).Methods("DELETE")
+	router.HandleFunc("/songs/{id:[0-9]+}", h.HandlePatchSong).Methods("PATCH")
+	auth.Require(types.RoleEditor, router.HandleFunc("/songs/trash", h.HandleGetTrash).Methods("GET"))
+	auth.Require(types.RoleAdmin, router.HandleFunc("/songs/trash/purge", h.HandlePurgeTrash).Methods("POST"))
+	router.HandleFunc("/songs/{id:[0-9]+}/restore", h.HandleRestoreSong).Methods("POST")
+	router.HandleFunc("/songs/{id:[0-9]+}/lyrics", h.HandleGetSongLyrics).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/tags", h.HandleAddSongTags).Methods("POST")
+	router.HandleFunc("/songs/{id:[0-9]+}/tags", h.HandleRemoveSongTags).Methods("DELETE")
+	router.HandleFunc("/tags", h.HandleGetTags).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/relations", h.HandleAddSongRelation).Methods("POST")
+	router.HandleFunc("/songs/{id:[0-9]+}/relations", h.HandleGetSongRelations).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/relations", h.HandleDeleteSongRelation).Methods("DELETE")
+	router.HandleFunc("/songs/{id:[0-9]+}/versions", h.HandleGetSongVersions).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/revisions", h.HandleGetSongRevisions).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/revisions/diff", h.HandleDiffSongRevisions).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/revisions/{rev:[0-9]+}", h.HandleGetSongRevision).Methods("GET")
+	router.HandleFunc("/songs/{id:[0-9]+}/revisions/{rev:[0-9]+}/revert", h.HandleRevertSong).Methods("POST")
 }
 
 // HandleAddSong adds a new song to the database.
 //
 // @Summary Add a new song
-// @Description Adds a new song with details retrieved from an external API.
+// @Description Adds a new song with details retrieved from an external API. The group is the main artist; additional primary, featured or remixer artists may be listed.
 // @Tags songs
 // @Accept  json
 // @Produce json
 // @Param payload body types.SongAddPayload true "Song data to add"
+// @Param on_conflict query string false "Set to refresh to update an existing duplicate with fresh details instead of failing" Enums(refresh)
 // @Success 201 {string} string "Song added successfully"
+// @Success 200 {string} string "Existing song refreshed"
 // @Failure 400 {string} string "Invalid input"
+// @Failure 409 {string} string "Song already exists, with its ID"
 // @Failure 500 {string} string "Failed to add song"
 // @Router /songs/add [post]
 func (h *Handler) HandleAddSong(w http.ResponseWriter, r *http.Request) {
 	const op = "Handler.HandleAddSong"
 	h.logs.Info("Starting request", "operation", op, "method", r.Method)
 
+	onConflict := r.URL.Query().Get("on_conflict")
+	if onConflict != "" && onConflict != onConflictRefresh {
+		h.logs.Error("Invalid on_conflict value", "operation", op, "value", onConflict)
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for on_conflict: must be %s", onConflictRefresh))
+		return
+	}
+
 	var payload types.SongAddPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
@@ -67,8 +100,14 @@ func (h *Handler) HandleAddSong(w http.ResponseWriter, r *http.Request) {
 	}
 	h.logs.Debug("Payload decoded", "operation", op, "payload", payload)
 
+	if err := normalizeArtists(payload.Artists); err != nil {
+		h.logs.Error("Invalid artists", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	externalAPI := config.Envs.ExtApi
-	songDetails, err := h.fetchSongDetailsFromAPI(payload.Group, payload.SongName, externalAPI)
+	songDetails, err := fetchSongDetails(r.Context(), h.apiClient, h.logs, payload.Group, payload.SongName, externalAPI)
 	if err != nil {
 		h.logs.Error("Error fetching song details", "operation", op, logger.Err(err))
 		http.Error(w, "Failed to fetch song details", http.StatusInternalServerError)
@@ -78,7 +117,20 @@ func (h *Handler) HandleAddSong(w http.ResponseWriter, r *http.Request) {
 	songLyrics := splitLyrics(songDetails.Text)
 	h.logs.Debug("Song lyrics processed", "operation", op, "lyrics_lines", len(songLyrics))
 
-	if err := h.store.AddSong(payload.SongName, payload.Group, songDetails, songLyrics); err != nil {
+	_, err = h.store.AddSong(payload.SongName, payload.Group, payload.Artists, songDetails, songLyrics, types.ActorFromContext(r.Context()))
+	var duplicate *types.DuplicateSongError
+	if errors.As(err, &duplicate) {
+		if onConflict == onConflictRefresh {
+			h.refreshSong(w, r, duplicate.ExistingID, songDetails, songLyrics)
+			return
+		}
+		h.logs.Warn("Song already exists", "operation", op, "existing_id", duplicate.ExistingID)
+		if err := httpapi.WriteJSON(w, http.StatusConflict, map[string]any{"error": duplicate.Error(), "id": duplicate.ExistingID}); err != nil {
+			h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+		}
+		return
+	}
+	if err != nil {
 		h.logs.Error("Error adding song to DB", "operation", op, logger.Err(err))
 		http.Error(w, "Failed to add song to the database", http.StatusInternalServerError)
 		return
@@ -89,104 +141,389 @@ func (h *Handler) HandleAddSong(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("Song added successfully"))
 }
 
+// maxImportBytes bounds the body of an import request.
+const maxImportBytes = 32 << 20
+
+// importFormats maps the content types of import files to their format.
+var importFormats = map[string]string{
+	"text/csv":             FormatCSV,
+	"application/x-ndjson": FormatNDJSON,
+	"application/jsonl":    FormatNDJSON,
+}
+
+// HandleImportSongs adds songs in bulk from a CSV or NDJSON file.
+//
+// @Summary Import songs
+// @Description Creates songs from a CSV file with a header row or from NDJSON with one object per line. Each row has song and group and optionally lyrics, published (YYYY-MM-DD or DD.MM.YYYY) and link; in CSV, lyrics are one cell with verses separated by blank lines, in NDJSON a string or a list of verses. Missing details are fetched from the external API. Songs already in the library, or repeated in the file, are skipped. With dry_run the rows are only validated and checked for duplicates. The report lists the outcome of every row.
+// @Tags songs
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param file body string true "CSV or NDJSON file"
+// @Param format query string false "File format, by default taken from the Content-Type" Enums(csv, ndjson)
+// @Param dry_run query bool false "Validate without creating songs"
+// @Success 200 {object} types.ImportReport "Import finished, see the report for every row"
+// @Failure 400 {string} string "File can't be read"
+// @Failure 413 {string} string "File is too large"
+// @Failure 500 {string} string "Failed to import songs"
+// @Router /songs/import [post]
+func (h *Handler) HandleImportSongs(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleImportSongs"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[mediaType]
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		h.logs.Error("Unknown import format", "operation", op, "format", format, "content_type", r.Header.Get("Content-Type"))
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for format: must be %s or %s, or send text/csv or application/x-ndjson", FormatCSV, FormatNDJSON))
+		return
+	}
+
+	dryRun := false
+	if v := query.Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			h.logs.Error("Invalid dry_run value", "operation", op, "value", v)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for dry_run: must be a boolean"))
+			return
+		}
+		dryRun = b
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	report, err := h.importer.Import(r.Context(), body, format, dryRun, types.ActorFromContext(r.Context()))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.logs.Error("Import file too large", "operation", op, "limit", tooLarge.Limit)
+		httpapi.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("import file is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		h.logs.Error("Error importing songs", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Import finished", "operation", op, "dry_run", dryRun, "created", report.Created, "failed", report.Failed)
+	if err := httpapi.WriteJSON(w, http.StatusOK, report); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// normalizeArtists checks an artist list, defaulting missing roles to
+// featured.
+func normalizeArtists(artists []types.SongArtist) error {
+	for i := range artists {
+		if strings.TrimSpace(artists[i].Name) == "" {
+			return errors.New("artist names must not be empty")
+		}
+		switch artists[i].Role {
+		case "":
+			artists[i].Role = types.ArtistRoleFeatured
+		case types.ArtistRolePrimary, types.ArtistRoleFeatured, types.ArtistRoleRemixer:
+		default:
+			return fmt.Errorf("invalid artist role '%s': must be primary, featured or remixer", artists[i].Role)
+		}
+	}
+	return nil
+}
+
+const onConflictRefresh = "refresh"
+
+// refreshSong overwrites an existing song with details freshly fetched from
+// the external API.
+func (h *Handler) refreshSong(w http.ResponseWriter, r *http.Request, id int, songDetails *types.SongDetail, songLyrics []string) {
+	const op = "Handler.refreshSong"
+
+	published, err := parseReleaseDate(songDetails.ReleaseDate)
+	if err != nil {
+		h.logs.Warn("Ignoring unparsable release date", "operation", op, "release_date", songDetails.ReleaseDate)
+	}
+
+	patch := &types.SongPatch{}
+	if len(songLyrics) > 0 {
+		patch.SongLyrics = types.PatchValue(songLyrics)
+	}
+	if !published.IsZero() {
+		patch.Published = types.PatchValue(published)
+	}
+	if songDetails.Link != "" {
+		patch.Link = types.PatchValue(songDetails.Link)
+	}
+
+	if err := h.store.UpdateSongInfo(id, 0, patch, types.ActorFromContext(r.Context())); err != nil {
+		h.logs.Error("Error refreshing song", "operation", op, "id", id, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Song refreshed successfully", "operation", op, "id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]any{"status": "song refreshed", "id": id}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// parseReleaseDate parses the release date reported by the external API,
+// which uses either types.DateLayout or the dd.mm.yyyy form.
+func parseReleaseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(types.DateLayout, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("02.01.2006", value)
+}
+
 // HandleGetSong retrieves songs based on query parameters.
 //
 // @Summary Retrieve songs
-// @Description Retrieves songs matching specified criteria through query parameters.
+// @Description Retrieves songs matching specified criteria through query parameters. Every song carries an etag to send as If-Match when updating or deleting it; when filtering by id the ETag header is set as well.
 // @Tags songs
 // @Accept json
 // @Produce json
 // @Param id query int false "ID of the song"
 // @Param song query string false "Name of the song"
-// @Param group query string false "Group name"
+// @Param group query string false "Group name, matched against every artist of the song"
 // @Param link query string false "Link to the song"
-// @Param time query string false "Time (RFC3339 format)"
+// @Param album query int false "ID of the album"
+// @Param published query string false "Exact publication date (YYYY-MM-DD)"
+// @Param published_from query string false "Earliest publication date, inclusive (YYYY-MM-DD)"
+// @Param published_to query string false "Latest publication date, inclusive (YYYY-MM-DD)"
+// @Param year query int false "Publication year"
+// @Param decade query int false "Publication decade, e.g. 1990"
 // @Param lyrics query string false "Lyrics as a JSON array"
-// @Param limit query int false "Maximum number of results to return"
-// @Param offset query int false "Offset for pagination"
-// @Success 200 {array} types.Song "Songs retrieved successfully"
+// @Param q query string false "Full-text search over song names and lyrics, ranked by relevance. Each result lists its matching verses with an HTML snippet: the lyric text is escaped and matched words are wrapped in <mark> tags."
+// @Param genre query string false "Genre, may be repeated"
+// @Param tag query string false "Tag, may be repeated"
+// @Param tag_mode query string false "Whether songs need any or all of the given genres and tags" Enums(any, all)
+// @Param match query string false "Matching mode for song and group: exact, prefix, contains or fuzzy" Enums(exact, prefix, contains, fuzzy)
+// @Param sort query string false "Comma-separated sort keys (id, song, group, published, rank, score), prefix with - for descending"
+// @Param limit query int false "Maximum number of results to return, capped at the configured maximum"
+// @Param after query string false "Opaque cursor returned as next_cursor by the previous page"
+// @Param offset query int false "Offset for pagination (deprecated, prefer after)"
+// @Param count query bool false "Include the total number of matching songs"
+// @Success 200 {object} types.SongPage "Songs retrieved successfully"
 // @Failure 400 {string} string "Invalid query parameter"
-// @Failure 404 {string} string "No songs found"
 // @Failure 500 {string} string "Failed to fetch songs"
 // @Router /songs/get [get]
 func (h *Handler) HandleGetSong(w http.ResponseWriter, r *http.Request) {
 	const op = "Handler.HandleGetSong"
 	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
 
-	// Expected types for validation
-	expectedTypes := map[string]string{
-		"id":     "int",
-		"song":   "string",
-		"group":  "string",
-		"link":   "string",
-		"time":   "time",
-		"lyrics": "array",
+	normalizedFilters, err := h.songFilters(r.URL.Query())
+	if err != nil {
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
-	// Retrieve and validate query parameters
-	normalizedFilters := url.Values{}
-	for key, values := range r.URL.Query() {
-		if len(values) == 0 {
-			continue
-		}
-
-		value := values[0]
-		if expectedType, exists := expectedTypes[key]; exists {
-			switch expectedType {
-			case "int":
-				if _, err := strconv.Atoi(value); err != nil {
-					h.logs.Error("Invalid integer value for filter", "key", key, "value", value, logger.Err(err))
-					WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for %s: must be an integer", key))
-					return
-				}
-			case "time":
-				if _, err := time.Parse(time.RFC3339, value); err != nil {
-					h.logs.Error("Invalid time value for filter", "key", key, "value", value, logger.Err(err))
-					WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for %s: must be a valid timestamp", key))
-					return
-				}
-			case "array":
-				var arr []string
-				if err := json.Unmarshal([]byte(value), &arr); err != nil {
-					h.logs.Error("Invalid array value for filter", "key", key, "value", value, logger.Err(err))
-					WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for %s: must be a valid array", key))
-					return
-				}
-			}
-		}
-		normalizedFilters.Add(key, value)
+	if normalizedFilters.Get("after") != "" && normalizedFilters.Get("offset") != "" {
+		h.logs.Error("Both after and offset provided", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("after and offset cannot be used together"))
+		return
 	}
 
 	// Fetch songs from storage
-	songs, err := h.store.GetSongs(normalizedFilters)
+	page, err := h.store.GetSongs(normalizedFilters)
 	if err != nil {
 		h.logs.Error("Error fetching songs", "operation", op, logger.Err(err))
-		WriteError(w, http.StatusInternalServerError, err)
+		httpapi.WriteError(w, storeErrorStatus(err), err)
 		return
 	}
 
-	if len(songs) == 0 {
-		h.logs.Warn("No songs found matching the criteria", "operation", op, "filters", normalizedFilters)
-		WriteError(w, http.StatusNotFound, errors.New("no songs found matching the criteria"))
-		return
+	if page.NextCursor != "" {
+		page.Links.Next = cursorLink(r, page.NextCursor)
+	}
+
+	for i := range page.Items {
+		page.Items[i].ETag = songETag(page.Items[i].ID, page.Items[i].Version)
+	}
+	if normalizedFilters.Get("id") != "" && len(page.Items) == 1 {
+		w.Header().Set("ETag", page.Items[0].ETag)
 	}
 
-	h.logs.Debug("Songs retrieved", "operation", op, "count", len(songs))
-	if err := WriteJSON(w, http.StatusOK, songs); err != nil {
+	h.logs.Debug("Songs retrieved", "operation", op, "count", len(page.Items))
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
 		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
 	}
 }
 
-// HandleDeleteSong deletes a song from the database.
+// HandleExportSongs streams every song matching the listing filters.
+//
+// @Summary Export songs
+// @Description Streams every song matching the filters of /songs/get, in the same order, as a file download. Paging parameters are ignored. CSV has a header row, lyrics in one cell with verses separated by blank lines and artists, genres and tags separated by semicolons; NDJSON has one song per line and JSON is a single array, both with lyrics as a list of verses. When an error occurs after the first song was sent, the download is cut short.
+// @Tags songs
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce json
+// @Param format query string true "Export format" Enums(csv, ndjson, json)
+// @Param id query int false "ID of the song"
+// @Param song query string false "Name of the song"
+// @Param group query string false "Group name, matched against every artist of the song"
+// @Param album query int false "ID of the album"
+// @Param year query int false "Publication year"
+// @Param q query string false "Full-text search over song names and lyrics"
+// @Param genre query string false "Genre, may be repeated"
+// @Param tag query string false "Tag, may be repeated"
+// @Param sort query string false "Comma-separated sort keys, as for /songs/get"
+// @Success 200 {array} types.ExportedSong "Songs in the requested format"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to export songs"
+// @Router /songs/export [get]
+func (h *Handler) HandleExportSongs(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleExportSongs"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		h.logs.Error("Unknown export format", "operation", op, "format", format)
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for format: must be %s, %s or %s", FormatCSV, FormatNDJSON, FormatJSON))
+		return
+	}
+	query.Del("format")
+
+	filters, err := h.songFilters(query)
+	if err != nil {
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Headers only go out with the first song, so errors before it still
+	// get a proper status
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="songs-%s.%s"`, time.Now().Format("20060102"), format))
+
+	count, err := Export(r.Context(), h.store, w, format, filters)
+	if err != nil && count == 0 {
+		h.logs.Error("Error exporting songs", "operation", op, logger.Err(err))
+		w.Header().Del("Content-Disposition")
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+	if err != nil {
+		h.logs.Error("Export cut short", "operation", op, "exported", count, logger.Err(err))
+		return
+	}
+
+	h.logs.Info("Songs exported", "operation", op, "format", format, "count", count)
+}
+
+// songFilterTypes are the query parameters of a song listing and how they
+// are validated.
+var songFilterTypes = map[string]string{
+	"id":             "int",
+	"song":           "string",
+	"group":          "string",
+	"link":           "string",
+	"album":          "int",
+	"published":      "date",
+	"published_from": "date",
+	"published_to":   "date",
+	"year":           "year",
+	"decade":         "year",
+	"lyrics":         "array",
+	"q":              "string",
+	"limit":          "positive",
+	"offset":         "non-negative",
+	"after":          "string",
+	"count":          "bool",
+	"sort":           "string",
+	"match":          "match",
+	"genre":          "string",
+	"tag":            "string",
+	"tag_mode":       "tag_mode",
+}
+
+// songFilters validates the query parameters of a song listing. Parameters
+// that can't be repeated keep their first value, booleans are normalized and
+// unknown parameters are passed through.
+func (h *Handler) songFilters(query url.Values) (url.Values, error) {
+	normalizedFilters := url.Values{}
+	for key, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+
+		// Only filters the store can combine keep every value
+		if !multiValueFilters[key] {
+			values = values[:1]
+		}
+
+		for _, value := range values {
+			if expectedType, exists := songFilterTypes[key]; exists {
+				switch expectedType {
+				case "int":
+					if _, err := strconv.Atoi(value); err != nil {
+						h.logs.Error("Invalid integer value for filter", "key", key, "value", value, logger.Err(err))
+						return nil, fmt.Errorf("invalid value for %s: must be an integer", key)
+					}
+				case "positive":
+					if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+						h.logs.Error("Invalid positive integer value for filter", "key", key, "value", value)
+						return nil, fmt.Errorf("invalid value for %s: must be a positive integer", key)
+					}
+				case "non-negative":
+					if n, err := strconv.Atoi(value); err != nil || n < 0 {
+						h.logs.Error("Invalid non-negative integer value for filter", "key", key, "value", value)
+						return nil, fmt.Errorf("invalid value for %s: must be a non-negative integer", key)
+					}
+				case "bool":
+					b, err := strconv.ParseBool(value)
+					if err != nil {
+						h.logs.Error("Invalid boolean value for filter", "key", key, "value", value, logger.Err(err))
+						return nil, fmt.Errorf("invalid value for %s: must be a boolean", key)
+					}
+					value = strconv.FormatBool(b)
+				case "date":
+					if _, err := parseDateFilter(key, value); err != nil {
+						h.logs.Error("Invalid date value for filter", "key", key, "value", value, logger.Err(err))
+						return nil, err
+					}
+				case "year":
+					if _, err := parseYearFilter(key, value); err != nil {
+						h.logs.Error("Invalid year value for filter", "key", key, "value", value, logger.Err(err))
+						return nil, err
+					}
+				case "match":
+					if !validMatchModes[value] {
+						h.logs.Error("Invalid match mode", "key", key, "value", value)
+						return nil, fmt.Errorf("invalid value for %s: must be one of exact, prefix, contains, fuzzy", key)
+					}
+				case "tag_mode":
+					if value != tagModeAny && value != tagModeAll {
+						h.logs.Error("Invalid tag mode", "key", key, "value", value)
+						return nil, fmt.Errorf("invalid value for %s: must be any or all", key)
+					}
+				case "array":
+					var arr []string
+					if err := json.Unmarshal([]byte(value), &arr); err != nil {
+						h.logs.Error("Invalid array value for filter", "key", key, "value", value, logger.Err(err))
+						return nil, fmt.Errorf("invalid value for %s: must be a valid array", key)
+					}
+				}
+			}
+			normalizedFilters.Add(key, value)
+		}
+	}
+	return normalizedFilters, nil
+}
+
+// HandleDeleteSong moves a song to the trash.
 //
 // @Summary Delete a song
-// @Description Deletes a song based on its name and group.
+// @Description Moves a song to the trash. It can be restored until the trash is purged. With If-Match the song is only deleted if it is unchanged since it was read.
 // @Tags songs
 // @Accept  json
 // @Param payload body types.SongDeletePayload true "delete the song based on ID"
+// @Param If-Match header string false "ETag of the song as last read"
 // @Produce json
 // @Success 200 {string} string "Song deleted successfully"
 // @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not found"
+// @Failure 412 {string} string "Song was changed since it was read"
 // @Failure 500 {string} string "Failed to delete song"
 // @Router /songs/delete [delete]
 func (h *Handler) HandleDeleteSong(w http.ResponseWriter, r *http.Request) {
@@ -194,26 +531,157 @@ func (h *Handler) HandleDeleteSong(w http.ResponseWriter, r *http.Request) {
 	h.logs.Info("Starting request", "operation", op, "method", r.Method)
 
 	var payload types.SongDeletePayload
-	if err := ParseJson(r, &payload); err != nil {
+	if err := httpapi.ParseJson(r, &payload); err != nil {
 		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
-		WriteError(w, http.StatusBadRequest, err)
+		httpapi.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	if payload.ID == 0 {
 		h.logs.Error("No identifier provided", "operation", op)
-		WriteError(w, http.StatusBadRequest, errors.New("either song name, group, link, or ID must be provided"))
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("either song name, group, link, or ID must be provided"))
 		return
 	}
 
-	if err := h.store.DeleteSong(payload.ID); err != nil {
+	version, ok := ifMatchVersion(r, payload.ID)
+	if !ok {
+		h.logs.Warn("If-Match does not match the song", "operation", op, "id", payload.ID, "if_match", r.Header.Get("If-Match"))
+		httpapi.WriteError(w, http.StatusPreconditionFailed, types.ErrVersionConflict)
+		return
+	}
+
+	if err := h.store.DeleteSong(payload.ID, version); err != nil {
 		h.logs.Error("Error deleting song", "operation", op, logger.Err(err))
-		WriteError(w, http.StatusInternalServerError, err)
+		writeSongError(w, payload.ID, err)
 		return
 	}
 
-	h.logs.Info("Song deleted successfully", "operation", op, "song_name")
-	if err := WriteJSON(w, http.StatusOK, map[string]string{"status": "song deleted"}); err != nil {
+	h.logs.Info("Song moved to trash", "operation", op, "song_id", payload.ID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "song moved to trash"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetTrash lists deleted songs.
+//
+// @Summary List the trash
+// @Description Returns a page of deleted songs, most recently deleted first, with the retention period after which they can be purged.
+// @Tags songs
+// @Produce json
+// @Param offset query int false "Offset for pagination"
+// @Param limit query int false "Maximum number of songs to return"
+// @Success 200 {object} types.TrashPage "Trash retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to fetch trash"
+// @Router /songs/trash [get]
+func (h *Handler) HandleGetTrash(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetTrash"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	offset, limit, err := httpapi.ParsePage(r.URL.Query(), config.Envs.DefaultPageSize, config.Envs.MaxPageSize)
+	if err != nil {
+		h.logs.Error("Invalid pagination parameters", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	songs, total, err := h.store.GetTrash(offset, limit)
+	if err != nil {
+		h.logs.Error("Error fetching trash", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	page := types.TrashPage{
+		Items:         songs,
+		Total:         total,
+		Offset:        offset,
+		Limit:         limit,
+		RetentionDays: config.Envs.TrashRetentionDays,
+	}
+	if offset+limit < total {
+		page.Links.Next = httpapi.PageLink(r, offset+limit, limit)
+	}
+	if offset > 0 {
+		page.Links.Prev = httpapi.PageLink(r, max(offset-limit, 0), limit)
+	}
+
+	h.logs.Debug("Trash retrieved", "operation", op, "count", len(songs), "total", total)
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRestoreSong takes a song out of the trash.
+//
+// @Summary Restore a song
+// @Description Restores a deleted song from the trash.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Success 200 {string} string "Song restored successfully"
+// @Failure 404 {string} string "Song not in trash"
+// @Failure 409 {string} string "Group already has a live song with this name"
+// @Failure 500 {string} string "Failed to restore song"
+// @Router /songs/{id}/restore [post]
+func (h *Handler) HandleRestoreSong(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRestoreSong"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RestoreSong(id); err != nil {
+		h.logs.Error("Error restoring song", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Song restored successfully", "operation", op, "song_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "song restored"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandlePurgeTrash permanently deletes old songs from the trash.
+//
+// @Summary Purge the trash
+// @Description Permanently deletes songs that have been in the trash longer than the retention period, then removes groups left without songs. Requires the admin role.
+// @Tags songs
+// @Produce json
+// @Security BearerAuth
+// @Param retention_days query int false "Override the configured retention period in days; 0 purges the whole trash"
+// @Success 200 {object} types.PurgeResult "Trash purged successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to purge trash"
+// @Router /songs/trash/purge [post]
+func (h *Handler) HandlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandlePurgeTrash"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	retentionDays := config.Envs.TrashRetentionDays
+	if v := r.URL.Query().Get("retention_days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			h.logs.Error("Invalid retention_days", "operation", op, "value", v)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for retention_days: must be a non-negative integer"))
+			return
+		}
+		retentionDays = days
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	purged, err := h.store.PurgeTrash(cutoff)
+	if err != nil {
+		h.logs.Error("Error purging trash", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Trash purged successfully", "operation", op, "purged", purged, "retention_days", retentionDays)
+	if err := httpapi.WriteJSON(w, http.StatusOK, types.PurgeResult{Purged: purged, Cutoff: cutoff}); err != nil {
 		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
 	}
 }
@@ -221,13 +689,17 @@ func (h *Handler) HandleDeleteSong(w http.ResponseWriter, r *http.Request) {
 // HandleUpdateSong updates song information.
 //
 // @Summary Update song
-// @Description Updates existing song details.
+// @Description Updates existing song details. When artists is given it replaces the song's additional artists. With If-Match the song is only updated if it is unchanged since it was read.
 // @Tags songs
 // @Accept json
 // @Param payload body types.Song true "update the song"
+// @Param If-Match header string false "ETag of the song as last read"
 // @Produce json
 // @Success 200 {string} string "Song updated successfully"
 // @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not found"
+// @Failure 409 {string} string "Group already has a song with this name"
+// @Failure 412 {string} string "Song was changed since it was read"
 // @Failure 500 {string} string "Failed to update song"
 // @Router /songs/update [put]
 func (h *Handler) HandleUpdateSong(w http.ResponseWriter, r *http.Request) {
@@ -235,79 +707,841 @@ func (h *Handler) HandleUpdateSong(w http.ResponseWriter, r *http.Request) {
 	h.logs.Info("Starting request", "operation", op, "method", r.Method)
 
 	var payload types.Song
-	if err := ParseJson(r, &payload); err != nil {
+	if err := httpapi.ParseJson(r, &payload); err != nil {
 		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
-		WriteError(w, http.StatusBadRequest, err)
+		httpapi.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	if payload.ID <= 0 {
 		h.logs.Error("Invalid ID", "operation", op, "error", "ID must be positive")
-		WriteError(w, http.StatusBadRequest, fmt.Errorf("ID must be positive"))
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("ID must be positive"))
 		return
 	}
 
 	h.logs.Info("Received payload", "operation", op, "payload", payload)
 
-	if err := h.store.UpdateSongInfo(payload.ID, payload.SongName, payload.Group, payload.SongLyrics, payload.Published, payload.Link); err != nil {
+	if err := normalizeArtists(payload.Artists); err != nil {
+		h.logs.Error("Invalid artists", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, ok := ifMatchVersion(r, payload.ID)
+	if !ok {
+		h.logs.Warn("If-Match does not match the song", "operation", op, "id", payload.ID, "if_match", r.Header.Get("If-Match"))
+		httpapi.WriteError(w, http.StatusPreconditionFailed, types.ErrVersionConflict)
+		return
+	}
+
+	if err := h.store.UpdateSongInfo(payload.ID, version, updatePatch(&payload), types.ActorFromContext(r.Context())); err != nil {
 		h.logs.Error("Error updating song", "operation", op, logger.Err(err))
-		WriteError(w, http.StatusInternalServerError, err)
+		writeSongError(w, payload.ID, err)
 		return
 	}
 
 	h.logs.Info("Song updated successfully", "operation", op, "song_id", payload.ID)
-	if err := WriteJSON(w, http.StatusOK, map[string]string{"status": "song updated"}); err != nil {
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "song updated"}); err != nil {
 		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
 	}
 }
 
-func ParseJson(r *http.Request, payload any) error {
-	if r.Body == nil {
-		return errors.New("missing request body")
+// updatePatch turns a PUT payload into a patch. PUT predates clearing fields:
+// empty values keep the current ones, and only a non-nil artists list
+// replaces the additional artists.
+func updatePatch(payload *types.Song) *types.SongPatch {
+	patch := &types.SongPatch{}
+	if payload.SongName != "" {
+		patch.SongName = types.PatchValue(payload.SongName)
 	}
-	return json.NewDecoder(r.Body).Decode(payload)
+	if payload.Group != "" {
+		patch.Group = types.PatchValue(payload.Group)
+	}
+	if payload.Artists != nil {
+		patch.Artists = types.PatchValue(payload.Artists)
+	}
+	if len(payload.SongLyrics) > 0 {
+		patch.SongLyrics = types.PatchValue(payload.SongLyrics)
+	}
+	if payload.Published != nil && !payload.Published.IsZero() {
+		patch.Published = types.PatchValue(*payload.Published)
+	}
+	if payload.Link != "" {
+		patch.Link = types.PatchValue(payload.Link)
+	}
+	return patch
 }
 
-func WriteJSON(w http.ResponseWriter, status int, v any) error {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(status)
-	return json.NewEncoder(w).Encode(v)
+// mergePatchType is the media type of a JSON merge patch (RFC 7396).
+const mergePatchType = "application/merge-patch+json"
+
+// HandlePatchSong applies a JSON merge patch to a song.
+//
+// @Summary Patch a song
+// @Description Applies a JSON merge patch (RFC 7396) to the song's name, group, artists, lyrics, publication date and link. Absent members are left unchanged and null clears a field; the name and group can't be cleared. Artists and lyrics are replaced as a whole. With If-Match the song is only changed if it is unchanged since it was read. Returns the patched song with its new ETag.
+// @Tags songs
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param patch body types.Song true "Members of the song to change"
+// @Param If-Match header string false "ETag of the song as last read"
+// @Success 200 {object} types.Song "Song patched successfully"
+// @Failure 400 {string} string "Invalid patch"
+// @Failure 404 {string} string "Song not found"
+// @Failure 409 {string} string "Group already has a song with this name"
+// @Failure 412 {string} string "Song was changed since it was read"
+// @Failure 415 {string} string "Body is not a JSON merge patch"
+// @Failure 500 {string} string "Failed to patch song"
+// @Router /songs/{id} [patch]
+func (h *Handler) HandlePatchSong(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandlePatchSong"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != mergePatchType {
+		h.logs.Error("Unsupported content type", "operation", op, "content_type", r.Header.Get("Content-Type"))
+		httpapi.WriteError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s", mergePatchType))
+		return
+	}
+
+	// Read-only members such as id or etag are rejected rather than
+	// silently ignored
+	var patch types.SongPatch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		h.logs.Error("Invalid patch", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("%w: %v", types.ErrInvalidPatch, err))
+		return
+	}
+
+	if err := normalizeArtists(patch.Artists.Value); err != nil {
+		h.logs.Error("Invalid artists", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, ok := ifMatchVersion(r, id)
+	if !ok {
+		h.logs.Warn("If-Match does not match the song", "operation", op, "id", id, "if_match", r.Header.Get("If-Match"))
+		httpapi.WriteError(w, http.StatusPreconditionFailed, types.ErrVersionConflict)
+		return
+	}
+
+	if err := h.store.UpdateSongInfo(id, version, &patch, types.ActorFromContext(r.Context())); err != nil {
+		h.logs.Error("Error patching song", "operation", op, logger.Err(err))
+		writeSongError(w, id, err)
+		return
+	}
+
+	page, err := h.store.GetSongs(url.Values{"id": {strconv.Itoa(id)}})
+	if err != nil {
+		h.logs.Error("Error fetching patched song", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+	if len(page.Items) == 0 {
+		httpapi.WriteError(w, http.StatusNotFound, types.ErrSongNotFound)
+		return
+	}
+	song := page.Items[0]
+	song.ETag = songETag(song.ID, song.Version)
+
+	h.logs.Info("Song patched successfully", "operation", op, "song_id", id, "version", song.Version)
+	w.Header().Set("ETag", song.ETag)
+	if err := httpapi.WriteJSON(w, http.StatusOK, song); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
 }
 
-func WriteError(w http.ResponseWriter, status int, err error) {
-	err = WriteJSON(w, status, map[string]string{"error": err.Error()})
+// HandleGetSongLyrics returns a page of verses for a single song.
+//
+// @Summary Retrieve song lyrics
+// @Description Returns verses of a song with their indexes, the total verse count and pagination links.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param offset query int false "Index of the first verse to return"
+// @Param limit query int false "Maximum number of verses to return"
+// @Success 200 {object} types.LyricsPage "Lyrics retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to fetch lyrics"
+// @Router /songs/{id}/lyrics [get]
+func (h *Handler) HandleGetSongLyrics(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetSongLyrics"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	offset, limit, err := httpapi.ParsePage(r.URL.Query(), defaultLyricsLimit, maxLyricsLimit)
+	if err != nil {
+		h.logs.Error("Invalid pagination parameters", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	verses, total, err := h.store.GetSongLyrics(id, offset, limit)
 	if err != nil {
-		log.Println(err)
+		h.logs.Error("Error fetching lyrics", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	page := types.LyricsPage{
+		SongID: id,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+		Verses: verses,
+	}
+	if offset+limit < total {
+		page.Links.Next = httpapi.PageLink(r, offset+limit, limit)
+	}
+	if offset > 0 {
+		page.Links.Prev = httpapi.PageLink(r, max(offset-limit, 0), limit)
+	}
+
+	h.logs.Debug("Lyrics retrieved", "operation", op, "id", id, "count", len(verses), "total", total)
+	if err := httpapi.WriteJSON(w, http.StatusOK, page); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
 	}
 }
 
-func (h *Handler) fetchSongDetailsFromAPI(group, song, externalAPI string) (*types.SongDetail, error) {
-	const op = "Handler.fetchSongDetailsFromAPI"
+// HandleAddSongTags attaches genres and tags to a song.
+//
+// @Summary Tag a song
+// @Description Adds genres and free-form tags to a song. Existing tags are kept.
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param payload body types.SongTagsPayload true "Genres and tags to add"
+// @Success 200 {string} string "Tags added successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to add tags"
+// @Router /songs/{id}/tags [post]
+func (h *Handler) HandleAddSongTags(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleAddSongTags"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.tagsRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AddSongTags(id, payload.Genres, payload.Tags); err != nil {
+		h.logs.Error("Error adding tags", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Tags added successfully", "operation", op, "song_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "tags added"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRemoveSongTags detaches genres and tags from a song.
+//
+// @Summary Untag a song
+// @Description Removes genres and free-form tags from a song.
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param payload body types.SongTagsPayload true "Genres and tags to remove"
+// @Success 200 {string} string "Tags removed successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to remove tags"
+// @Router /songs/{id}/tags [delete]
+func (h *Handler) HandleRemoveSongTags(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRemoveSongTags"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.tagsRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.RemoveSongTags(id, payload.Genres, payload.Tags); err != nil {
+		h.logs.Error("Error removing tags", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Tags removed successfully", "operation", op, "song_id", id)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "tags removed"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetTags lists genres and tags with song counts.
+//
+// @Summary List tags
+// @Description Returns genres and tags with the number of songs using each, most used first.
+// @Tags songs
+// @Produce json
+// @Param kind query string false "Only list genres or only tags" Enums(genre, tag)
+// @Success 200 {array} types.TagCount "Tags retrieved successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to fetch tags"
+// @Router /tags [get]
+func (h *Handler) HandleGetTags(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetTags"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != types.TagKindGenre && kind != types.TagKindTag {
+		h.logs.Error("Invalid tag kind", "operation", op, "kind", kind)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for kind: must be genre or tag"))
+		return
+	}
+
+	tags, err := h.store.GetTags(kind)
+	if err != nil {
+		h.logs.Error("Error fetching tags", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Debug("Tags retrieved", "operation", op, "count", len(tags))
+	if err := httpapi.WriteJSON(w, http.StatusOK, tags); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// tagsRequest reads the song ID and tags payload, writing a 400 when either
+// is invalid.
+func (h *Handler) tagsRequest(w http.ResponseWriter, r *http.Request, op string) (int, types.SongTagsPayload, bool) {
+	var payload types.SongTagsPayload
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return 0, payload, false
+	}
+
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return 0, payload, false
+	}
+	for _, name := range append(append([]string{}, payload.Genres...), payload.Tags...) {
+		if strings.TrimSpace(name) == "" {
+			h.logs.Error("Empty tag name", "operation", op)
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("genre and tag names must not be empty"))
+			return 0, payload, false
+		}
+	}
+	if len(payload.Genres) == 0 && len(payload.Tags) == 0 {
+		h.logs.Error("No tags provided", "operation", op)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("at least one genre or tag must be provided"))
+		return 0, payload, false
+	}
+	return id, payload, true
+}
+
+// HandleAddSongRelation links a song to the original it is a version of.
+//
+// @Summary Relate a song to its original
+// @Description Records that the song is a cover, remix, live version or translation of another song.
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the derived song"
+// @Param payload body types.SongRelationPayload true "Original song and kind of relation"
+// @Success 201 {string} string "Relation added successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Song not found"
+// @Failure 409 {string} string "Relation already exists"
+// @Failure 500 {string} string "Failed to add relation"
+// @Router /songs/{id}/relations [post]
+func (h *Handler) HandleAddSongRelation(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleAddSongRelation"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.relationRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.AddSongRelation(id, payload.OriginalID, payload.Kind); err != nil {
+		h.logs.Error("Error adding relation", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Relation added successfully", "operation", op, "song_id", id, "original_id", payload.OriginalID)
+	if err := httpapi.WriteJSON(w, http.StatusCreated, map[string]string{"status": "relation added"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetSongRelations lists the direct relations of a song.
+//
+// @Summary List song relations
+// @Description Returns the originals the song was derived from and the versions derived from it.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Success 200 {array} types.SongRelation "Relations retrieved successfully"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to fetch relations"
+// @Router /songs/{id}/relations [get]
+func (h *Handler) HandleGetSongRelations(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetSongRelations"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	relations, err := h.store.GetSongRelations(id)
+	if err != nil {
+		h.logs.Error("Error fetching relations", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, relations); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDeleteSongRelation removes a relation between two songs.
+//
+// @Summary Delete a song relation
+// @Description Removes the link between a song and its original.
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the derived song"
+// @Param payload body types.SongRelationPayload true "Original song and kind of relation"
+// @Success 200 {string} string "Relation deleted successfully"
+// @Failure 400 {string} string "Invalid input"
+// @Failure 404 {string} string "Relation not found"
+// @Failure 500 {string} string "Failed to delete relation"
+// @Router /songs/{id}/relations [delete]
+func (h *Handler) HandleDeleteSongRelation(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDeleteSongRelation"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, payload, ok := h.relationRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteSongRelation(id, payload.OriginalID, payload.Kind); err != nil {
+		h.logs.Error("Error deleting relation", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Relation deleted successfully", "operation", op, "song_id", id, "original_id", payload.OriginalID)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]string{"status": "relation deleted"}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetSongVersions returns every song connected to a song through
+// relations.
+//
+// @Summary List song versions
+// @Description Walks the relation graph from the song in both directions and returns originals, covers, remixes, live versions and translations, nearest first.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Success 200 {array} types.SongVersion "Versions retrieved successfully"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to fetch versions"
+// @Router /songs/{id}/versions [get]
+func (h *Handler) HandleGetSongVersions(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetSongVersions"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	versions, err := h.store.GetSongVersions(id)
+	if err != nil {
+		h.logs.Error("Error fetching versions", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Debug("Versions retrieved", "operation", op, "song_id", id, "count", len(versions))
+	if err := httpapi.WriteJSON(w, http.StatusOK, versions); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetSongRevisions lists the revision history of a song.
+//
+// @Summary List song revisions
+// @Description Returns every recorded revision of the song, newest first, with who made it, when, and which fields changed.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Success 200 {array} types.SongRevision "Revisions retrieved successfully"
+// @Failure 404 {string} string "Song not found"
+// @Failure 500 {string} string "Failed to fetch revisions"
+// @Router /songs/{id}/revisions [get]
+func (h *Handler) HandleGetSongRevisions(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetSongRevisions"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.store.GetSongRevisions(id)
+	if err != nil {
+		h.logs.Error("Error fetching revisions", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Debug("Revisions retrieved", "operation", op, "song_id", id, "count", len(revisions))
+	if err := httpapi.WriteJSON(w, http.StatusOK, revisions); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleGetSongRevision returns a single revision with the song's state at
+// that point.
+//
+// @Summary Get a song revision
+// @Description Returns a revision together with a snapshot of the song's name, group, artists, lyrics, publication date and link as they were after it.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param rev path int true "Revision number"
+// @Success 200 {object} types.SongRevision "Revision retrieved successfully"
+// @Failure 404 {string} string "Song or revision not found"
+// @Failure 500 {string} string "Failed to fetch revision"
+// @Router /songs/{id}/revisions/{rev} [get]
+func (h *Handler) HandleGetSongRevision(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetSongRevision"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, rev, ok := h.revisionRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	revision, err := h.store.GetSongRevision(id, rev)
+	if err != nil {
+		h.logs.Error("Error fetching revision", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	if err := httpapi.WriteJSON(w, http.StatusOK, revision); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleDiffSongRevisions compares two revisions of a song.
+//
+// @Summary Diff two song revisions
+// @Description Lists the fields that differ between two revisions and the verses removed from and added to the lyrics. Without to the latest revision is used; without from the one before to.
+// @Tags songs
+// @Produce json
+// @Param id path int true "ID of the song"
+// @Param from query int false "Older revision number"
+// @Param to query int false "Newer revision number"
+// @Success 200 {object} types.RevisionDiff "Diff computed successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 404 {string} string "Song or revision not found"
+// @Failure 500 {string} string "Failed to compute diff"
+// @Router /songs/{id}/revisions/diff [get]
+func (h *Handler) HandleDiffSongRevisions(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleDiffSongRevisions"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return
+	}
+
+	var revs [2]int
+	for i, name := range []string{"from", "to"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.logs.Error("Invalid revision number", "operation", op, name, v)
+			httpapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid value for %s: must be a positive integer", name))
+			return
+		}
+		revs[i] = n
+	}
+	from, to := revs[0], revs[1]
+
+	if to == 0 {
+		revisions, err := h.store.GetSongRevisions(id)
+		if err != nil {
+			h.logs.Error("Error fetching revisions", "operation", op, logger.Err(err))
+			httpapi.WriteError(w, storeErrorStatus(err), err)
+			return
+		}
+		if len(revisions) == 0 {
+			httpapi.WriteError(w, http.StatusNotFound, types.ErrRevisionNotFound)
+			return
+		}
+		to = revisions[0].Revision
+	}
+	if from == 0 {
+		if to == 1 {
+			httpapi.WriteError(w, http.StatusBadRequest, errors.New("revision 1 has no earlier revision to compare with"))
+			return
+		}
+		from = to - 1
+	}
+
+	older, err := h.store.GetSongRevision(id, from)
+	if err != nil {
+		h.logs.Error("Error fetching revision", "operation", op, "revision", from, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+	newer, err := h.store.GetSongRevision(id, to)
+	if err != nil {
+		h.logs.Error("Error fetching revision", "operation", op, "revision", to, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	fields, verses := diffSnapshots(older.Snapshot, newer.Snapshot)
+	diff := types.RevisionDiff{SongID: id, From: from, To: to, Fields: fields, Verses: verses}
+	if err := httpapi.WriteJSON(w, http.StatusOK, diff); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// HandleRevertSong restores a song to an earlier revision.
+//
+// @Summary Revert a song to a revision
+// @Description Restores the song's name, group, artists, lyrics, publication date and link from the revision. The revert is recorded as a new revision, whose number is returned.
+// @Tags songs
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID of the song"
+// @Param rev path int true "Revision number to restore"
+// @Success 200 {object} map[string]any "Song reverted successfully"
+// @Failure 400 {string} string "Invalid path parameter"
+// @Failure 404 {string} string "Song or revision not found"
+// @Failure 409 {string} string "Group already has a song with this name"
+// @Failure 500 {string} string "Failed to revert song"
+// @Router /songs/{id}/revisions/{rev}/revert [post]
+func (h *Handler) HandleRevertSong(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleRevertSong"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method)
+
+	id, rev, ok := h.revisionRequest(w, r, op)
+	if !ok {
+		return
+	}
+
+	latest, err := h.store.RevertSong(id, rev, types.ActorFromContext(r.Context()))
+	if err != nil {
+		h.logs.Error("Error reverting song", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, storeErrorStatus(err), err)
+		return
+	}
+
+	h.logs.Info("Song reverted successfully", "operation", op, "song_id", id, "revision", rev)
+	if err := httpapi.WriteJSON(w, http.StatusOK, map[string]any{"status": "song reverted", "revision": latest}); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// revisionRequest reads the song ID and revision number from the path,
+// writing a 400 when either is invalid.
+func (h *Handler) revisionRequest(w http.ResponseWriter, r *http.Request, op string) (int, int, bool) {
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return 0, 0, false
+	}
+	rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil || rev <= 0 {
+		h.logs.Error("Invalid revision number", "operation", op, "rev", mux.Vars(r)["rev"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for rev: must be a positive integer"))
+		return 0, 0, false
+	}
+	return id, rev, true
+}
+
+// relationRequest reads the song ID and relation payload, writing a 400 when
+// either is invalid.
+func (h *Handler) relationRequest(w http.ResponseWriter, r *http.Request, op string) (int, types.SongRelationPayload, bool) {
+	var payload types.SongRelationPayload
+
+	id, ok := h.songID(w, r, op)
+	if !ok {
+		return 0, payload, false
+	}
+
+	if err := httpapi.ParseJson(r, &payload); err != nil {
+		h.logs.Error("Invalid input", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return 0, payload, false
+	}
+	if payload.OriginalID <= 0 || payload.OriginalID == id {
+		h.logs.Error("Invalid original ID", "operation", op, "originalId", payload.OriginalID)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("originalId must be a positive ID of another song"))
+		return 0, payload, false
+	}
+	switch payload.Kind {
+	case types.RelationCover, types.RelationRemix, types.RelationLive, types.RelationTranslation:
+	default:
+		h.logs.Error("Invalid relation kind", "operation", op, "kind", payload.Kind)
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("kind must be cover, remix, live or translation"))
+		return 0, payload, false
+	}
+	return id, payload, true
+}
+
+// songETag is the entity tag of a version of a song. It names the song too,
+// so an ETag read from one song never matches another.
+func songETag(id, version int) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// ifMatchVersion returns the version of the song required by the If-Match
+// header, or 0 when there is no header or it is "*". It reports false when
+// the header can't match the song at all, such as an ETag of another song or
+// a weak one, which If-Match never accepts.
+func ifMatchVersion(r *http.Request, id int) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	tag, ok := strings.CutPrefix(header, fmt.Sprintf(`"%d-`, id))
+	if !ok {
+		return 0, false
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// writeSongError writes a store error for a song. On a version conflict the
+// ETag of the current version is sent along, so the client can re-read the
+// song and retry.
+func writeSongError(w http.ResponseWriter, id int, err error) {
+	var conflict *types.VersionConflictError
+	if errors.As(err, &conflict) {
+		w.Header().Set("ETag", songETag(id, conflict.Current))
+	}
+	httpapi.WriteError(w, storeErrorStatus(err), err)
+}
+
+// songID reads the song ID from the path, writing a 400 when it is invalid.
+func (h *Handler) songID(w http.ResponseWriter, r *http.Request, op string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		h.logs.Error("Invalid song ID", "operation", op, "id", mux.Vars(r)["id"])
+		httpapi.WriteError(w, http.StatusBadRequest, errors.New("invalid value for id: must be a positive integer"))
+		return 0, false
+	}
+	return id, true
+}
+
+// storeErrorStatus maps errors returned by the store to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrSongNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrInvalidFilter), errors.Is(err, types.ErrInvalidPatch), errors.Is(err, types.ErrInvalidImport):
+		return http.StatusBadRequest
+	case errors.Is(err, types.ErrRelationNotFound), errors.Is(err, types.ErrRevisionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrDuplicateSong), errors.Is(err, types.ErrRelationExists):
+		return http.StatusConflict
+	case errors.Is(err, types.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// fetchSongDetails asks the external API for the details of a song.
+func fetchSongDetails(ctx context.Context, client *resty.Client, logs *slog.Logger, group, song, externalAPI string) (*types.SongDetail, error) {
+	const op = "song.fetchSongDetails"
 
 	apiURL := fmt.Sprintf("%s/info?group=%s&song=%s", externalAPI, url.QueryEscape(group), url.QueryEscape(song))
 
-	h.logs.Debug("Making API request", "operation", op, "url", apiURL)
+	logs.Debug("Making API request", "operation", op, "url", apiURL)
 
-	resp, err := h.apiClient.R().Get(apiURL)
+	resp, err := client.R().SetContext(ctx).Get(apiURL)
 	if err != nil {
-		h.logs.Error("Error fetching from API", "operation", op, logger.Err(err))
+		logs.Error("Error fetching from API", "operation", op, logger.Err(err))
 		return nil, err
 	}
 
-	h.logs.Debug("API response received", "operation", op, "status_code", resp.StatusCode())
+	logs.Debug("API response received", "operation", op, "status_code", resp.StatusCode())
 	if resp.StatusCode() != http.StatusOK {
 		return nil, fmt.Errorf("API request failed with status code %d", resp.StatusCode())
 	}
 
 	var songDetails types.SongDetail
 	if err := json.Unmarshal(resp.Body(), &songDetails); err != nil {
-		h.logs.Error("Error unmarshalling API response", "operation", op, logger.Err(err))
+		logs.Error("Error unmarshalling API response", "operation", op, logger.Err(err))
 		return nil, err
 	}
 
 	return &songDetails, nil
 }
 
+const (
+	defaultLyricsLimit = 10
+	maxLyricsLimit     = 100
+)
+
+// multiValueFilters lists the query parameters that may be repeated, e.g.
+// group=Muse&group=Queen.
+var multiValueFilters = map[string]bool{
+	"id":     true,
+	"album":  true,
+	"song":   true,
+	"group":  true,
+	"link":   true,
+	"lyrics": true,
+	"genre":  true,
+	"tag":    true,
+}
+
+// cursorLink rebuilds the request URL so that it continues after the cursor.
+func cursorLink(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Del("offset")
+	query.Set("after", cursor)
+	return r.URL.Path + "?" + query.Encode()
+}
+
 func splitLyrics(text string) []string {
 	if text == "" {
 		return nil
@@ -1,10 +1,15 @@
 package song
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/db"
 	"github.com/genryusaishigikuni/muse_lib/logger" // Import the logger package
+	"github.com/genryusaishigikuni/muse_lib/normalize"
 	"github.com/genryusaishigikuni/muse_lib/types"
 	"github.com/lib/pq"
 	"log/slog"
@@ -14,6 +19,30 @@ import (
 	"time"
 )
 
+// highlightStart and highlightStop are the markers ts_headline puts around
+// matched words. They are control characters, stripped from the verses first,
+// so the snippet can be HTML-escaped before they become <mark> tags.
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+// headlineOptions controls how ts_headline marks matched words in snippets.
+const headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxWords=20, MinWords=5"
+
+// snippetReplacer escapes a snippet for HTML and turns the highlight markers
+// into <mark> tags. Lyrics are user content, so nothing else in a snippet may
+// reach a client as markup.
+var snippetReplacer = strings.NewReplacer(
+	highlightStart, "<mark>",
+	highlightStop, "</mark>",
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&#34;",
+	"'", "&#39;",
+)
+
 type Store struct {
 	db  *sql.DB
 	log *slog.Logger
@@ -26,26 +55,290 @@ func NewStore(db *sql.DB, env string) *Store {
 	return &Store{db: db, log: log}
 }
 
-func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
+func (s *Store) GetSongs(filters url.Values) (*types.SongPage, error) {
 	const op = "song.GetSongs"
 	s.log.Debug("Fetching songs with filters", "operation", op, "filters", filters)
 
-	baseQuery := `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link 
-                  FROM songs s
-                  JOIN groups g ON s.songGroupId = g.id`
-	var whereClauses []string
+	q, err := buildSongQuery(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := pageLimit(filters)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if o := filters.Get("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("%w: 'offset' must be a non-negative integer", types.ErrInvalidFilter)
+		}
+	}
+
+	page := &types.SongPage{Items: []types.Song{}}
+
+	if filters.Get("count") == "true" {
+		countQuery := `SELECT COUNT(*)` + q.from
+		if len(q.where) > 0 {
+			countQuery += " WHERE " + strings.Join(q.where, " AND ")
+		}
+		var total int
+		if err := s.db.QueryRow(countQuery, q.args...).Scan(&total); err != nil {
+			s.log.Error("Error counting songs", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	if after := filters.Get("after"); after != "" {
+		c, err := decodeCursor(q.orderKeys, after)
+		if err != nil {
+			return nil, err
+		}
+		condition, cursorArgs := keysetCondition(q.orderKeys, c, q.argIndex)
+		q.where = append(q.where, condition)
+		q.args = append(q.args, cursorArgs...)
+		q.argIndex += len(cursorArgs)
+	}
+
+	baseQuery := q.selectSQL()
+
+	// Fetch one extra row to find out whether there is a next page.
+	baseQuery += fmt.Sprintf(" LIMIT %d", limit+1)
+	if offset > 0 {
+		baseQuery += fmt.Sprintf(" OFFSET %d", offset)
+	}
+
+	s.log.Debug("Executing query", "operation", op, "query", baseQuery, "args", q.args)
+
+	rows, err := s.db.Query(baseQuery, q.args...)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var song types.Song
+		if err := q.scan(rows, &song); err != nil {
+			s.log.Error("Error scanning song", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		page.Items = append(page.Items, song)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating songs", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeCursor(q.orderKeys, &page.Items[limit-1])
+	}
+
+	s.log.Debug("Fetched songs", "operation", op, "songs_count", len(page.Items))
+	return page, nil
+}
+
+// exportBatchSize is the number of rows ExportSongs fetches from its cursor
+// at a time.
+const exportBatchSize = 500
+
+// ExportSongs passes every song matching the GetSongs filters to fn, in the
+// order GetSongs lists them; limit, offset, after and count are ignored. Rows
+// are fetched in batches from a server-side cursor in a read-only
+// transaction, so the export is a consistent snapshot that is never held in
+// memory as a whole. An error from fn stops the export and is returned.
+func (s *Store) ExportSongs(ctx context.Context, filters url.Values, fn func(*types.Song) error) error {
+	const op = "song.ExportSongs"
+	s.log.Debug("Exporting songs with filters", "operation", op, "filters", filters)
+
+	q, err := buildSongQuery(filters)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		s.log.Error("Error starting export transaction", "operation", op, logger.Err(err))
+		return err
+	}
+	// Nothing is written, so the transaction is never committed
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	query := `DECLARE song_export NO SCROLL CURSOR FOR ` + q.selectSQL()
+	s.log.Debug("Declaring export cursor", "operation", op, "query", query, "args", q.args)
+	if _, err := tx.ExecContext(ctx, query, q.args...); err != nil {
+		s.log.Error("Error declaring export cursor", "operation", op, logger.Err(err))
+		return err
+	}
+
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM song_export`, exportBatchSize)
+	fetchBatch := func() (int, error) {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return 0, err
+		}
+		defer func(rows *sql.Rows) {
+			_ = rows.Close()
+		}(rows)
+
+		n := 0
+		for rows.Next() {
+			var song types.Song
+			if err := q.scan(rows, &song); err != nil {
+				return n, err
+			}
+			n++
+			if err := fn(&song); err != nil {
+				return n, err
+			}
+		}
+		return n, rows.Err()
+	}
+
+	total := 0
+	for {
+		n, err := fetchBatch()
+		total += n
+		if err != nil {
+			s.log.Error("Error exporting songs", "operation", op, "exported", total, logger.Err(err))
+			return err
+		}
+		if n < exportBatchSize {
+			break
+		}
+	}
+
+	s.log.Debug("Exported songs", "operation", op, "songs_count", total)
+	return nil
+}
+
+// songQuery is the song listing shared by GetSongs and ExportSongs, built from
+// the filters without any paging.
+type songQuery struct {
+	columns   string
+	from      string
+	where     []string
+	args      []interface{}
+	argIndex  int
+	orderKeys []sortKey
+	search    bool
+	scored    bool
+}
+
+// selectSQL returns the ordered SELECT statement of the query.
+func (q *songQuery) selectSQL() string {
+	query := `SELECT ` + q.columns + q.from
+	if len(q.where) > 0 {
+		query += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	return query + orderByClause(q.orderKeys)
+}
+
+// scan reads a row of the query into song.
+func (q *songQuery) scan(rows *sql.Rows, song *types.Song) error {
+	var groupName string
+	var albumID, trackNumber sql.NullInt64
+	var albumTitle sql.NullString
+	var artists []byte
+	dest := []interface{}{&song.ID, &song.SongName, &groupName, pq.Array(&song.SongLyrics), &song.Published, &song.Link, &song.Version, &albumID, &albumTitle, &trackNumber,
+		pq.Array(&song.Genres), pq.Array(&song.Tags), &artists}
+	var matches []byte
+	if q.search {
+		dest = append(dest, &song.Rank, &matches)
+	}
+	if q.scored {
+		dest = append(dest, &song.Score)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	if len(artists) > 0 {
+		if err := json.Unmarshal(artists, &song.Artists); err != nil {
+			return fmt.Errorf("decoding song artists: %w", err)
+		}
+	}
+	if len(matches) > 0 {
+		if err := json.Unmarshal(matches, &song.Matches); err != nil {
+			return fmt.Errorf("decoding verse matches: %w", err)
+		}
+		for i := range song.Matches {
+			song.Matches[i].Snippet = snippetReplacer.Replace(song.Matches[i].Snippet)
+		}
+	}
+	song.Group = groupName
+	if albumID.Valid {
+		song.Album = &types.SongAlbum{ID: int(albumID.Int64), Title: albumTitle.String, TrackNumber: int(trackNumber.Int64)}
+	}
+	return nil
+}
+
+// buildSongQuery turns the listing filters into a songQuery. Invalid filters
+// are reported as types.ErrInvalidFilter.
+func buildSongQuery(filters url.Values) (*songQuery, error) {
+	selectColumns := `s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link, s.version, a.id, a.title, s.trackNumber,
+                  (SELECT array_agg(t.name ORDER BY t.name) FROM song_tags st JOIN tags t ON st.tagId = t.id
+                   WHERE st.songId = s.id AND t.kind = 'genre') AS genres,
+                  (SELECT array_agg(t.name ORDER BY t.name) FROM song_tags st JOIN tags t ON st.tagId = t.id
+                   WHERE st.songId = s.id AND t.kind = 'tag') AS tags,
+                  (SELECT json_agg(json_build_object('id', ga.id, 'name', ga.groupName, 'role', sa.role) ORDER BY sa.position)
+                   FROM song_artists sa JOIN groups ga ON sa.groupId = ga.id
+                   WHERE sa.songId = s.id) AS artists`
+	// Songs in the trash are only listed by GetTrash.
+	whereClauses := []string{"s.deletedAt IS NULL"}
 	var args []interface{}
 	argIndex := 1
 
+	orderKeys := []sortKey{idKey}
+	rankExpr := ""
+
+	search := strings.TrimSpace(filters.Get("q"))
+	if search != "" {
+		tsQuery := fmt.Sprintf("websearch_to_tsquery('simple', $%d)", argIndex)
+		args = append(args, search)
+		argIndex++
+
+		rankExpr = fmt.Sprintf("ts_rank_cd(s.searchVector, %s)", tsQuery)
+		orderKeys = []sortKey{rankKey(rankExpr), idKey}
+
+		selectColumns += fmt.Sprintf(`, %[1]s AS rank,
+                  (SELECT json_agg(json_build_object(
+                              'index', v.idx - 1,
+                              'snippet', ts_headline('simple', translate(v.verse, E'\x01\x02', ''), %[2]s, '%[3]s')) ORDER BY v.idx)
+                   FROM unnest(s.songLyrics) WITH ORDINALITY AS v(verse, idx)
+                   WHERE to_tsvector('simple', v.verse) @@ %[2]s) AS matches`, rankExpr, tsQuery, headlineOptions)
+		whereClauses = append(whereClauses, fmt.Sprintf("s.searchVector @@ %s", tsQuery))
+	}
+
+	fromClause := `
+                  FROM songs s
+                  JOIN groups g ON s.songGroupId = g.id
+                  LEFT JOIN albums a ON s.albumId = a.id`
+
 	filterMappings := map[string]string{
-		"song":      "s.songName",
-		"group":     "g.groupName",
-		"published": "s.published",
-		"lyrics":    "s.songLyrics",
-		"link":      "s.link",
-		"id":        "s.id",
+		"song":   "s.songName",
+		"group":  "g.groupName",
+		"lyrics": "s.songLyrics",
+		"link":   "s.link",
+		"id":     "s.id",
+		"album":  "s.albumId",
 	}
 
+	matchMode := filters.Get("match")
+	if matchMode == "" {
+		matchMode = matchExact
+	}
+	if !validMatchModes[matchMode] {
+		return nil, fmt.Errorf("%w: invalid value for 'match': must be one of exact, prefix, contains, fuzzy", types.ErrInvalidFilter)
+	}
+	var scoreExprs []string
+
 	for key, values := range filters {
 		columnName, ok := filterMappings[key]
 		if !ok {
@@ -53,7 +346,35 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 		}
 
 		switch columnName {
-		case "s.songName", "g.groupName", "s.link":
+		case "g.groupName":
+			// Groups match a song through any of its artists.
+			if matchMode == matchExact {
+				clause, clauseArgs := groupKeyClause(values, argIndex)
+				whereClauses = append(whereClauses, clause)
+				args = append(args, clauseArgs...)
+				argIndex += len(clauseArgs)
+				continue
+			}
+			clause, similarity, clauseArgs := nameMatchClause("ga.groupName", matchMode, values, argIndex)
+			whereClauses = append(whereClauses, fmt.Sprintf(`EXISTS (SELECT 1 FROM song_artists sa JOIN groups ga ON sa.groupId = ga.id
+                  WHERE sa.songId = s.id AND %s)`, clause))
+			args = append(args, clauseArgs...)
+			argIndex += len(clauseArgs)
+			if similarity != "" {
+				scoreExprs = append(scoreExprs, fmt.Sprintf(`(SELECT MAX(%s) FROM song_artists sa JOIN groups ga ON sa.groupId = ga.id
+                  WHERE sa.songId = s.id)`, similarity))
+			}
+
+		case "s.songName":
+			clause, similarity, clauseArgs := nameMatchClause(columnName, matchMode, values, argIndex)
+			whereClauses = append(whereClauses, clause)
+			args = append(args, clauseArgs...)
+			argIndex += len(clauseArgs)
+			if similarity != "" {
+				scoreExprs = append(scoreExprs, similarity)
+			}
+
+		case "s.link":
 			if len(values) > 1 {
 				placeholders := make([]string, len(values))
 				for i, v := range values {
@@ -61,6 +382,9 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 					args = append(args, v)
 					argIndex++
 				}
+				for i, p := range placeholders {
+					placeholders[i] = "LOWER(" + p + ")"
+				}
 				whereClauses = append(whereClauses, fmt.Sprintf("LOWER(%s) IN (%s)", columnName, strings.Join(placeholders, ", ")))
 			} else {
 				whereClauses = append(whereClauses, fmt.Sprintf("LOWER(%s) = LOWER($%d)", columnName, argIndex))
@@ -68,17 +392,6 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 				argIndex++
 			}
 
-		case "s.published":
-			if len(values) == 1 {
-				publishedTime, err := time.Parse("2006-01-02", values[0])
-				if err != nil {
-					return nil, fmt.Errorf("invalid date format for 'published': %v", err)
-				}
-				whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", columnName, argIndex))
-				args = append(args, publishedTime)
-				argIndex++
-			}
-
 		case "s.songLyrics":
 			if len(values) > 0 {
 				placeholders := make([]string, len(values))
@@ -90,11 +403,24 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 				whereClauses = append(whereClauses, fmt.Sprintf("%s && ARRAY[%s]::text[]", columnName, strings.Join(placeholders, ", ")))
 			}
 
+		case "s.albumId":
+			placeholders := make([]string, len(values))
+			for i, v := range values {
+				albumID, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("%w: invalid album format: %v", types.ErrInvalidFilter, err)
+				}
+				placeholders[i] = fmt.Sprintf("$%d", argIndex)
+				args = append(args, albumID)
+				argIndex++
+			}
+			whereClauses = append(whereClauses, fmt.Sprintf("%s IN (%s)", columnName, strings.Join(placeholders, ", ")))
+
 		case "s.id":
 			if len(values) == 1 {
 				id, err := strconv.Atoi(values[0])
 				if err != nil {
-					return nil, fmt.Errorf("invalid id format: %v", err)
+					return nil, fmt.Errorf("%w: invalid id format: %v", types.ErrInvalidFilter, err)
 				}
 				whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", columnName, argIndex))
 				args = append(args, id)
@@ -104,7 +430,7 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 				for i, v := range values {
 					id, err := strconv.Atoi(v)
 					if err != nil {
-						return nil, fmt.Errorf("invalid id format: %v", err)
+						return nil, fmt.Errorf("%w: invalid id format: %v", types.ErrInvalidFilter, err)
 					}
 					placeholders[i] = fmt.Sprintf("$%d", argIndex)
 					args = append(args, id)
@@ -116,61 +442,270 @@ func (s *Store) GetSongs(filters url.Values) ([]types.Song, error) {
 		}
 	}
 
-	if len(whereClauses) > 0 {
-		baseQuery += " WHERE " + strings.Join(whereClauses, " AND ")
+	tagMode := filters.Get("tag_mode")
+	if tagMode == "" {
+		tagMode = tagModeAny
 	}
-
-	limit := 10
-	offset := 0
-	if l, ok := filters["limit"]; ok && len(l) > 0 {
-		limit, _ = strconv.Atoi(l[0])
+	if tagMode != tagModeAny && tagMode != tagModeAll {
+		return nil, fmt.Errorf("%w: invalid value for 'tag_mode': must be any or all", types.ErrInvalidFilter)
 	}
-	if o, ok := filters["offset"]; ok && len(o) > 0 {
-		offset, _ = strconv.Atoi(o[0])
+	for filter, kind := range map[string]string{"genre": types.TagKindGenre, "tag": types.TagKindTag} {
+		if values := filters[filter]; len(values) > 0 {
+			clause, clauseArgs := tagClause(kind, tagMode, values, argIndex)
+			whereClauses = append(whereClauses, clause)
+			args = append(args, clauseArgs...)
+			argIndex += len(clauseArgs)
+		}
 	}
-	baseQuery += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
 
-	s.log.Debug("Executing query", "operation", op, "query", baseQuery, "args", args)
+	scoreExpr := ""
+	if len(scoreExprs) > 0 {
+		scoreExpr = fmt.Sprintf("((%s) / %d)", strings.Join(scoreExprs, " + "), len(scoreExprs))
+		selectColumns += ", " + scoreExpr + " AS score"
+		if search == "" {
+			orderKeys = []sortKey{scoreKey(scoreExpr), idKey}
+		}
+	}
 
-	rows, err := s.db.Query(baseQuery, args...)
+	from, to, err := publishedRange(filters)
 	if err != nil {
-		s.log.Error("Error executing query", "operation", op, logger.Err(err))
 		return nil, err
 	}
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
+	if !from.IsZero() {
+		whereClauses = append(whereClauses, fmt.Sprintf("s.published >= $%d", argIndex))
+		args = append(args, from)
+		argIndex++
+	}
+	if !to.IsZero() {
+		whereClauses = append(whereClauses, fmt.Sprintf("s.published < $%d", argIndex))
+		args = append(args, to)
+		argIndex++
+	}
+
+	if sortParam := filters.Get("sort"); sortParam != "" {
+		var computed []sortKey
+		if rankExpr != "" {
+			computed = append(computed, rankKey(rankExpr))
+		}
+		if scoreExpr != "" {
+			computed = append(computed, scoreKey(scoreExpr))
+		}
+		keys, err := parseSort(sortParam, computed...)
 		if err != nil {
+			return nil, err
+		}
+		orderKeys = keys
+	}
+
+	return &songQuery{
+		columns:   selectColumns,
+		from:      fromClause,
+		where:     whereClauses,
+		args:      args,
+		argIndex:  argIndex,
+		orderKeys: orderKeys,
+		search:    search != "",
+		scored:    scoreExpr != "",
+	}, nil
+}
+
+const (
+	matchExact    = "exact"
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+	matchFuzzy    = "fuzzy"
+)
+
+var validMatchModes = map[string]bool{
+	matchExact:    true,
+	matchPrefix:   true,
+	matchContains: true,
+	matchFuzzy:    true,
+}
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// nameMatchClause builds a case-insensitive condition matching the column
+// against any of the values using the given match mode. In fuzzy mode it
+// also returns the trigram similarity of the best matching value.
+func nameMatchClause(column, mode string, values []string, argIndex int) (string, string, []interface{}) {
+	var args []interface{}
+	var conditions, similarities []string
+	for _, v := range values {
+		placeholder := fmt.Sprintf("$%d", argIndex)
+		argIndex++
+
+		switch mode {
+		case matchPrefix:
+			conditions = append(conditions, fmt.Sprintf("LOWER(%s) LIKE LOWER(%s) || '%%'", column, placeholder))
+			args = append(args, likeEscaper.Replace(v))
+		case matchContains:
+			conditions = append(conditions, fmt.Sprintf("LOWER(%s) LIKE '%%' || LOWER(%s) || '%%'", column, placeholder))
+			args = append(args, likeEscaper.Replace(v))
+		case matchFuzzy:
+			conditions = append(conditions, fmt.Sprintf("LOWER(%s) %% LOWER(%s)", column, placeholder))
+			similarities = append(similarities, fmt.Sprintf("similarity(LOWER(%s), LOWER(%s))", column, placeholder))
+			args = append(args, v)
+		default:
+			conditions = append(conditions, fmt.Sprintf("LOWER(%s) = LOWER(%s)", column, placeholder))
+			args = append(args, v)
 		}
-	}(rows)
+	}
 
-	var songs []types.Song
-	for rows.Next() {
-		var song types.Song
-		var groupName string
-		err := rows.Scan(&song.ID, &song.SongName, &groupName, pq.Array(&song.SongLyrics), &song.Published, &song.Link)
+	similarity := ""
+	if len(similarities) > 0 {
+		similarity = "GREATEST(" + strings.Join(similarities, ", ") + ")"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", similarity, args
+}
+
+const (
+	tagModeAny = "any"
+	tagModeAll = "all"
+)
+
+// tagClause matches songs carrying any, or all, of the named tags of a kind.
+func tagClause(kind, mode string, values []string, argIndex int) (string, []interface{}) {
+	names := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		name := normalize.Tag(v)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	clause := fmt.Sprintf(`s.id IN (SELECT st.songId FROM song_tags st JOIN tags t ON st.tagId = t.id
+                  WHERE t.kind = $%d AND t.name = ANY($%d)`, argIndex, argIndex+1)
+	if mode == tagModeAll {
+		clause += fmt.Sprintf(" GROUP BY st.songId HAVING COUNT(*) = %d", len(names))
+	}
+	return clause + ")", []interface{}{kind, pq.Array(names)}
+}
+
+// groupKeyClause matches songs having any artist whose normalized key is one
+// of the values, either directly or through one of the artist's aliases.
+func groupKeyClause(values []string, argIndex int) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		placeholders[i] = fmt.Sprintf("$%d", argIndex)
+		args[i] = normalize.GroupKey(v, config.Envs.GroupStripThe)
+		argIndex++
+	}
+	list := strings.Join(placeholders, ", ")
+	return fmt.Sprintf(`s.id IN (SELECT sa.songId FROM song_artists sa JOIN groups ga ON sa.groupId = ga.id
+                  WHERE ga.groupKey IN (%[1]s) OR ga.id IN (SELECT groupId FROM group_aliases WHERE alias IN (%[1]s)))`, list), args
+}
+
+// parseDateFilter parses a date filter value. All date filters share the
+// types.DateLayout format so that the handler and the store agree.
+func parseDateFilter(key, value string) (time.Time, error) {
+	t, err := time.Parse(types.DateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid value for '%s': must be a date in YYYY-MM-DD format", types.ErrInvalidFilter, key)
+	}
+	return t, nil
+}
+
+// parseYearFilter parses a year or decade filter value. Decades must be a
+// multiple of ten, e.g. 1990.
+func parseYearFilter(key, value string) (int, error) {
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1 || year > 9999 {
+		return 0, fmt.Errorf("%w: invalid value for '%s': must be a four-digit year", types.ErrInvalidFilter, key)
+	}
+	if key == "decade" && year%10 != 0 {
+		return 0, fmt.Errorf("%w: invalid value for '%s': must be a multiple of ten", types.ErrInvalidFilter, key)
+	}
+	return year, nil
+}
+
+// publishedRange combines the published, published_from, published_to, year
+// and decade filters into a half-open [from, to) range. A zero bound means
+// the range is open on that side.
+func publishedRange(filters url.Values) (time.Time, time.Time, error) {
+	var from, to time.Time
+	narrow := func(lower, upper time.Time) {
+		if !lower.IsZero() && (from.IsZero() || lower.After(from)) {
+			from = lower
+		}
+		if !upper.IsZero() && (to.IsZero() || upper.Before(to)) {
+			to = upper
+		}
+	}
+
+	if v := filters.Get("published"); v != "" {
+		day, err := parseDateFilter("published", v)
 		if err != nil {
-			s.log.Error("Error scanning song", "operation", op, logger.Err(err))
-			return nil, err
+			return from, to, err
 		}
-		song.Group = groupName
-		songs = append(songs, song)
+		narrow(day, day.AddDate(0, 0, 1))
+	}
+	var fromDay time.Time
+	if v := filters.Get("published_from"); v != "" {
+		day, err := parseDateFilter("published_from", v)
+		if err != nil {
+			return from, to, err
+		}
+		fromDay = day
+		narrow(day, time.Time{})
+	}
+	if v := filters.Get("published_to"); v != "" {
+		day, err := parseDateFilter("published_to", v)
+		if err != nil {
+			return from, to, err
+		}
+		if !fromDay.IsZero() && fromDay.After(day) {
+			return from, to, fmt.Errorf("%w: 'published_from' must not be after 'published_to'", types.ErrInvalidFilter)
+		}
+		narrow(time.Time{}, day.AddDate(0, 0, 1))
+	}
+	if v := filters.Get("year"); v != "" {
+		year, err := parseYearFilter("year", v)
+		if err != nil {
+			return from, to, err
+		}
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		narrow(start, start.AddDate(1, 0, 0))
+	}
+	if v := filters.Get("decade"); v != "" {
+		decade, err := parseYearFilter("decade", v)
+		if err != nil {
+			return from, to, err
+		}
+		start := time.Date(decade, time.January, 1, 0, 0, 0, 0, time.UTC)
+		narrow(start, start.AddDate(10, 0, 0))
 	}
 
-	s.log.Debug("Fetched songs", "operation", op, "songs_count", len(songs))
-	return songs, nil
+	return from, to, nil
 }
 
-func (s *Store) DeleteSong(id int) error {
-	const op = "song.DeleteSong"
-	s.log.Info("Deleting song", "operation", op, "id", id)
+// pageLimit parses the requested page size, falling back to the configured
+// default and capping it at the configured maximum.
+func pageLimit(filters url.Values) (int, error) {
+	l := filters.Get("limit")
+	if l == "" {
+		return config.Envs.DefaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("%w: 'limit' must be a positive integer", types.ErrInvalidFilter)
+	}
+	return min(limit, config.Envs.MaxPageSize), nil
+}
 
-	// Build delete query based on song ID
-	query := `DELETE FROM songs WHERE id = $1`
+// DeleteSong moves a song to the trash. It stays out of listings until it is
+// restored or purged; its groups are only cleaned up by PurgeTrash. A non-zero
+// version must match the song's current version, otherwise a
+// *types.VersionConflictError is returned.
+func (s *Store) DeleteSong(id, version int) error {
+	const op = "song.DeleteSong"
+	s.log.Info("Moving song to trash", "operation", op, "id", id, "version", version)
 
-	// Execute the delete query
-	s.log.Debug("Executing song delete query", "query", query, "args", id)
-	result, err := s.db.Exec(query, id)
+	query := `UPDATE songs SET deletedAt = NOW() WHERE id = $1 AND deletedAt IS NULL AND ($2 = 0 OR version = $2)`
+	result, err := s.db.Exec(query, id, version)
 	if err != nil {
 		s.log.Error("Error deleting song", "operation", op, "id", id, logger.Err(err))
 		return err
@@ -181,188 +716,863 @@ func (s *Store) DeleteSong(id int) error {
 		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
 		return err
 	}
-
 	if rowsAffected == 0 {
-		s.log.Warn("No song found to delete", "operation", op, "id", id)
-		return errors.New("song not found")
+		var current int
+		err := s.db.QueryRow(`SELECT version FROM songs WHERE id = $1 AND deletedAt IS NULL`, id).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("No song found to delete", "operation", op, "id", id)
+			return types.ErrSongNotFound
+		}
+		if err != nil {
+			s.log.Error("Error fetching song version", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+		s.log.Warn("Stale song version", "operation", op, "id", id, "version", version, "current", current)
+		return &types.VersionConflictError{Current: current}
 	}
 
-	s.log.Info("Song deleted successfully", "operation", op, "id", id)
+	s.log.Info("Song moved to trash", "operation", op, "id", id)
 	return nil
 }
 
-func (s *Store) UpdateSongInfo(id int, name, group string, lyrics interface{}, published time.Time, link string) error {
-	const op = "song.UpdateSongInfo"
-	s.log.Info("Updating song info", "operation", op, "id", id, "name", name, "group", group)
+// GetTrash lists the songs in the trash, most recently deleted first.
+func (s *Store) GetTrash(offset, limit int) ([]types.Song, int, error) {
+	const op = "song.GetTrash"
+	s.log.Debug("Fetching trash", "operation", op, "offset", offset, "limit", limit)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM songs WHERE deletedAt IS NOT NULL`).Scan(&total); err != nil {
+		s.log.Error("Error counting trash", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
 
-	var songExists bool
-	query := `SELECT EXISTS(SELECT 1 FROM songs WHERE id = $1)`
-	err := s.db.QueryRow(query, id).Scan(&songExists)
+	query := `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link, s.deletedAt
+              FROM songs s
+              JOIN groups g ON s.songGroupId = g.id
+              WHERE s.deletedAt IS NOT NULL
+              ORDER BY s.deletedAt DESC, s.id
+              OFFSET $1 LIMIT $2`
+	rows, err := s.db.Query(query, offset, limit)
 	if err != nil {
-		s.log.Error("Error checking song existence", "operation", op, logger.Err(err))
-		return err
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, 0, err
 	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
 
-	if !songExists {
-		s.log.Warn("Song not found", "operation", op, "id", id)
-		return fmt.Errorf("song with ID %d not found", id)
+	songs := make([]types.Song, 0, limit)
+	for rows.Next() {
+		var song types.Song
+		var deletedAt time.Time
+		if err := rows.Scan(&song.ID, &song.SongName, &song.Group, pq.Array(&song.SongLyrics), &song.Published, &song.Link, &deletedAt); err != nil {
+			s.log.Error("Error scanning song", "operation", op, logger.Err(err))
+			return nil, 0, err
+		}
+		song.DeletedAt = &deletedAt
+		songs = append(songs, song)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating trash", "operation", op, logger.Err(err))
+		return nil, 0, err
 	}
 
-	groupId := -1
-	var oldGroupId int
+	s.log.Debug("Fetched trash", "operation", op, "songs_count", len(songs), "total", total)
+	return songs, total, nil
+}
+
+// RestoreSong takes a song out of the trash. It fails with ErrDuplicateSong
+// when the group got a live song with the same name in the meantime.
+func (s *Store) RestoreSong(id int) error {
+	const op = "song.RestoreSong"
+	s.log.Info("Restoring song", "operation", op, "id", id)
+
+	result, err := s.db.Exec(`UPDATE songs SET deletedAt = NULL WHERE id = $1 AND deletedAt IS NOT NULL`, id)
+	if err != nil {
+		if db.IsUniqueViolation(err) {
+			s.log.Warn("Restore would duplicate a song", "operation", op, "id", id)
+			return types.ErrDuplicateSong
+		}
+		s.log.Error("Error restoring song", "operation", op, "id", id, logger.Err(err))
+		return err
+	}
 
-	query = `SELECT songGroupId FROM songs WHERE id = $1`
-	err = s.db.QueryRow(query, id).Scan(&oldGroupId)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		s.log.Error("Error fetching old groupId", "operation", op, "id", id, logger.Err(err))
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
 		return err
 	}
+	if rowsAffected == 0 {
+		s.log.Warn("Song not in trash", "operation", op, "id", id)
+		return fmt.Errorf("song with ID %d in trash: %w", id, types.ErrSongNotFound)
+	}
+
+	s.log.Info("Song restored successfully", "operation", op, "id", id)
+	return nil
+}
 
-	if group != "" {
-		query = `SELECT id FROM groups WHERE groupName = $1`
-		err = s.db.QueryRow(query, group).Scan(&groupId)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			s.log.Error("Error fetching groupId", "operation", op, "group", group, logger.Err(err))
-			return fmt.Errorf("group '%s' not found", group)
+// PurgeTrash permanently deletes the songs that were moved to the trash
+// before cutoff, then removes the groups left without songs. It returns the
+// number of songs deleted.
+func (s *Store) PurgeTrash(cutoff time.Time) (int, error) {
+	const op = "song.PurgeTrash"
+	s.log.Info("Purging trash", "operation", op, "cutoff", cutoff)
+
+	var purged int
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		query := `SELECT songGroupId FROM songs WHERE deletedAt < $1
+                  UNION
+                  SELECT sa.groupId FROM song_artists sa JOIN songs s ON sa.songId = s.id WHERE s.deletedAt < $1`
+		rows, err := tx.Query(query, cutoff)
+		if err != nil {
+			s.log.Error("Error fetching groups of purged songs", "operation", op, logger.Err(err))
+			return err
+		}
+		var groupIDs []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			groupIDs = append(groupIDs, id)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
 		}
 
-		if errors.Is(err, sql.ErrNoRows) {
-			query = `INSERT INTO groups (groupName) VALUES ($1) RETURNING id`
-			err = s.db.QueryRow(query, group).Scan(&groupId)
-			if err != nil {
-				s.log.Error("Error creating new group", "operation", op, "group", group, logger.Err(err))
-				return fmt.Errorf("could not create group '%s'", group)
+		result, err := tx.Exec(`DELETE FROM songs WHERE deletedAt < $1`, cutoff)
+		if err != nil {
+			s.log.Error("Error purging songs", "operation", op, logger.Err(err))
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+			return err
+		}
+		purged = int(n)
+
+		for _, gid := range groupIDs {
+			if err := s.deleteOrphanGroup(tx, gid); err != nil {
+				return err
 			}
-			s.log.Info("Created new group", "operation", op, "group", group, "groupId", groupId)
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	var currentSong types.Song
-	query = `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link
-			 FROM songs s
-			 JOIN groups g ON s.songGroupId = g.id
-			 WHERE s.id = $1`
-	err = s.db.QueryRow(query, id).Scan(&currentSong.ID, &currentSong.SongName, &currentSong.Group, pq.Array(&currentSong.SongLyrics), &currentSong.Published, &currentSong.Link)
-	if err != nil {
-		s.log.Error("Error fetching current song", "operation", op, "id", id, logger.Err(err))
-	} else {
-		s.log.Info("Current song info before update", "operation", op, "song", currentSong)
+	s.log.Info("Trash purged", "operation", op, "purged", purged)
+	return purged, nil
+}
+
+// UpdateSongInfo applies a patch to a song. Fields absent from the patch are
+// kept; null ones are cleared, which the name and group can't be. Artists
+// replace the song's additional artists. A non-zero version must match the
+// song's current version, otherwise a *types.VersionConflictError is
+// returned. The new state is recorded as a revision made by actor.
+func (s *Store) UpdateSongInfo(id, version int, patch *types.SongPatch, actor string) error {
+	const op = "song.UpdateSongInfo"
+	s.log.Info("Updating song info", "operation", op, "id", id, "version", version, "patch", patch)
+
+	if patch.SongName.Set && strings.TrimSpace(patch.SongName.Value) == "" {
+		return fmt.Errorf("%w: song name can't be empty", types.ErrInvalidPatch)
+	}
+	if patch.Group.Set && strings.TrimSpace(patch.Group.Value) == "" {
+		return fmt.Errorf("%w: group can't be empty", types.ErrInvalidPatch)
 	}
 
-	query = `UPDATE songs SET `
-	var args []interface{}
-	argIndex := 1
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		var currentSong types.Song
+		var oldGroupId int
+		query := `SELECT s.id, s.songName, g.groupName, s.songLyrics, s.published, s.link, s.version, s.songGroupId
+				 FROM songs s
+				 JOIN groups g ON s.songGroupId = g.id
+				 WHERE s.id = $1 AND s.deletedAt IS NULL
+				 FOR UPDATE OF s`
+		err := tx.QueryRow(query, id).Scan(&currentSong.ID, &currentSong.SongName, &currentSong.Group, pq.Array(&currentSong.SongLyrics), &currentSong.Published, &currentSong.Link, &currentSong.Version, &oldGroupId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.log.Warn("Song not found", "operation", op, "id", id)
+				return fmt.Errorf("song with ID %d: %w", id, types.ErrSongNotFound)
+			}
+			s.log.Error("Error fetching current song", "operation", op, "id", id, logger.Err(err))
+			return err
+		}
+		s.log.Debug("Current song info before update", "operation", op, "id", currentSong.ID, "song", currentSong.SongName, "group", currentSong.Group, "version", currentSong.Version)
 
-	if l, ok := lyrics.([]string); ok && len(l) > 0 {
-		query += fmt.Sprintf("songLyrics = $%d, ", argIndex)
-		args = append(args, pq.Array(l))
-		argIndex++
+		if version != 0 && version != currentSong.Version {
+			s.log.Warn("Stale song version", "operation", op, "id", id, "version", version, "current", currentSong.Version)
+			return &types.VersionConflictError{Current: currentSong.Version}
+		}
+
+		groupId := -1
+		if group := patch.Group.Value; patch.Group.Set {
+			groupId, err = s.upsertGroup(tx, group)
+			if err != nil {
+				s.log.Error("Error resolving group", "operation", op, "group", group, logger.Err(err))
+				return fmt.Errorf("could not resolve group '%s': %w", group, err)
+			}
+		}
+
+		// Every change bumps the version, including one to the artists only
+		query = `UPDATE songs SET version = version + 1, `
+		var args []interface{}
+		argIndex := 1
+
+		if patch.SongLyrics.Set {
+			// Cleared lyrics are stored as an empty list
+			lyrics := patch.SongLyrics.Value
+			if lyrics == nil {
+				lyrics = []string{}
+			}
+			query += fmt.Sprintf("songLyrics = $%d, ", argIndex)
+			args = append(args, pq.Array(lyrics))
+			argIndex++
+		}
+
+		if name := patch.SongName.Value; patch.SongName.Set {
+			query += fmt.Sprintf("songName = $%d, songKey = $%d, ", argIndex, argIndex+1)
+			args = append(args, name, normalize.SongKey(name))
+			argIndex += 2
+		}
+
+		if groupId > -1 {
+			query += fmt.Sprintf("songGroupId = $%d, ", argIndex)
+			args = append(args, groupId)
+			argIndex++
+		}
+
+		if patch.Published.Set {
+			var published interface{}
+			if !patch.Published.Null {
+				published = patch.Published.Value
+			}
+			query += fmt.Sprintf("published = $%d, ", argIndex)
+			args = append(args, published)
+			argIndex++
+		}
+
+		if patch.Link.Set {
+			// Cleared links are stored as an empty string
+			query += fmt.Sprintf("link = $%d, ", argIndex)
+			args = append(args, patch.Link.Value)
+			argIndex++
+		}
+
+		if len(args) == 0 && !patch.Artists.Set {
+			s.log.Warn("No fields to update", "operation", op)
+			return fmt.Errorf("%w: no fields to update", types.ErrInvalidPatch)
+		}
+
+		mainGroupID := oldGroupId
+		if groupId > -1 {
+			mainGroupID = groupId
+		}
+		if patch.Artists.Set || mainGroupID != oldGroupId {
+			refs, err := s.resolveArtists(tx, patch.Artists.Value)
+			if err != nil {
+				s.log.Error("Error resolving artists", "operation", op, logger.Err(err))
+				return err
+			}
+			if !patch.Artists.Set {
+				if refs, err = s.extraArtists(tx, id); err != nil {
+					s.log.Error("Error fetching song artists", "operation", op, logger.Err(err))
+					return err
+				}
+			}
+			if err := s.setSongArtists(tx, id, mainGroupID, refs); err != nil {
+				return err
+			}
+		}
+
+		query = query[:len(query)-2]
+
+		query += ` WHERE id = $` + fmt.Sprintf("%d", argIndex)
+		args = append(args, id)
+
+		s.log.Info("Executing update query", "operation", op, "query", query, "args", args)
+
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			if db.IsUniqueViolation(err) {
+				s.log.Warn("Update would duplicate a song", "operation", op, "id", id)
+				return types.ErrDuplicateSong
+			}
+			s.log.Error("Error executing update query", "operation", op, "query", query, "args", args, logger.Err(err))
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
+			return err
+		}
+
+		s.log.Info("Rows affected", "operation", op, "rows_affected", rowsAffected)
+
+		if rowsAffected == 0 {
+			s.log.Warn("No changes made to the song info", "operation", op, "id", id)
+			return fmt.Errorf("no changes made to the song with ID %d", id)
+		}
+
+		if _, err := s.recordRevision(tx, id, types.RevisionUpdate, actor, 0); err != nil {
+			return err
+		}
+
+		s.log.Info("Song info updated successfully", "operation", op, "id", id)
+		return nil
+	})
+}
+
+// AddSong inserts a new song and returns its ID. When the group already has a
+// song with the same normalized name, a *types.DuplicateSongError carrying
+// the existing song's ID is returned instead. The song starts its revision
+// history as created by actor.
+func (s *Store) AddSong(song, group string, artists []types.SongArtist, songDetails *types.SongDetail, songLyrics []string, actor string) (int, error) {
+	const op = "song.AddSong"
+	s.log.Info("Adding new song", "operation", op, "name", song, "group", group)
+
+	// The external API sometimes reports a zero or negative length; store
+	// those as unknown rather than failing the duration check
+	duration := songDetails.Duration
+	if duration <= 0 {
+		if duration < 0 {
+			s.log.Warn("Ignoring invalid duration", "operation", op, "duration", duration)
+		}
+		duration = 0
 	}
 
-	if name != "" {
-		query += fmt.Sprintf("songName = $%d, ", argIndex)
-		args = append(args, name)
-		argIndex++
+	var songID int
+	err := db.WithTx(s.db, func(tx *sql.Tx) error {
+		groupID, err := s.upsertGroup(tx, group)
+		if err != nil {
+			s.log.Error("Error resolving group", "operation", op, "group", group, logger.Err(err))
+			return err
+		}
+
+		songKey := normalize.SongKey(song)
+		query := `INSERT INTO songs (songName, songKey, songGroupId, songLyrics, published, link, duration) 
+	              VALUES ($1, $2, $3, $4, NULLIF($5, '')::timestamp, $6, NULLIF($7, 0))
+	              ON CONFLICT (songGroupId, songKey) WHERE deletedAt IS NULL DO NOTHING
+	              RETURNING id`
+		err = tx.QueryRow(query, song, songKey, groupID, pq.Array(songLyrics), songDetails.ReleaseDate, songDetails.Link, duration).Scan(&songID)
+		if errors.Is(err, sql.ErrNoRows) {
+			var existingID int
+			err = tx.QueryRow(`SELECT id FROM songs WHERE songGroupId = $1 AND songKey = $2 AND deletedAt IS NULL`, groupID, songKey).Scan(&existingID)
+			if err != nil {
+				s.log.Error("Error fetching duplicate song", "operation", op, "name", song, "group", group, logger.Err(err))
+				return err
+			}
+			s.log.Warn("Song already exists", "operation", op, "name", song, "group", group, "existing_id", existingID)
+			return &types.DuplicateSongError{ExistingID: existingID}
+		}
+		if err != nil {
+			s.log.Error("Error adding song", "operation", op, "name", song, "group", group, logger.Err(err))
+			return err
+		}
+
+		refs, err := s.resolveArtists(tx, artists)
+		if err != nil {
+			s.log.Error("Error resolving artists", "operation", op, logger.Err(err))
+			return err
+		}
+		if err := s.setSongArtists(tx, songID, groupID, refs); err != nil {
+			return err
+		}
+		if _, err := s.recordRevision(tx, songID, types.RevisionCreate, actor, 0); err != nil {
+			return err
+		}
+
+		s.log.Info("Song added successfully", "operation", op, "name", song, "group", group, "id", songID)
+		return nil
+	})
+	return songID, err
+}
+
+// FindSong returns the ID of the live song with the given name in the named
+// group, or 0 when there is none. Names are matched the way AddSong matches
+// duplicates, so a song found here would be rejected by AddSong.
+func (s *Store) FindSong(name, group string) (int, error) {
+	const op = "song.FindSong"
+
+	query := `SELECT s.id
+              FROM songs s
+              WHERE s.songKey = $1 AND s.deletedAt IS NULL
+                AND s.songGroupId = COALESCE((SELECT groupId FROM group_aliases WHERE alias = $2),
+                                             (SELECT id FROM groups WHERE groupKey = $2))`
+	var id int
+	err := s.db.QueryRow(query, normalize.SongKey(name), normalize.GroupKey(group, config.Envs.GroupStripThe)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		s.log.Error("Error looking up song", "operation", op, "name", name, "group", group, logger.Err(err))
+		return 0, err
 	}
+	return id, nil
+}
 
-	if groupId > -1 {
-		query += fmt.Sprintf("songGroupId = $%d, ", argIndex)
-		args = append(args, groupId)
-		argIndex++
+// upsertGroup returns the ID of the named group, creating it if needed. Names
+// are matched on their normalized key, and keys recorded as aliases resolve to
+// the group they point at. The no-op update makes RETURNING yield the
+// existing row on conflict.
+func (s *Store) upsertGroup(tx *sql.Tx, group string) (int, error) {
+	key := normalize.GroupKey(group, config.Envs.GroupStripThe)
+
+	var groupID int
+	err := tx.QueryRow(`SELECT groupId FROM group_aliases WHERE alias = $1`, key).Scan(&groupID)
+	if err == nil {
+		return groupID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
 	}
 
-	if !published.IsZero() {
-		query += fmt.Sprintf("published = $%d, ", argIndex)
-		args = append(args, published)
-		argIndex++
+	query := `INSERT INTO groups (groupName, groupKey) VALUES ($1, $2)
+              ON CONFLICT (groupKey) DO UPDATE SET groupKey = EXCLUDED.groupKey
+              RETURNING id`
+	err = tx.QueryRow(query, normalize.GroupName(group), key).Scan(&groupID)
+	return groupID, err
+}
+
+// artistRef is a resolved entry of a song's artist list.
+type artistRef struct {
+	groupID int
+	role    string
+}
+
+// resolveArtists maps artist names to group IDs, creating groups as needed.
+func (s *Store) resolveArtists(tx *sql.Tx, artists []types.SongArtist) ([]artistRef, error) {
+	refs := make([]artistRef, 0, len(artists))
+	for _, artist := range artists {
+		groupID, err := s.upsertGroup(tx, artist.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve artist '%s': %w", artist.Name, err)
+		}
+		refs = append(refs, artistRef{groupID: groupID, role: artist.Role})
 	}
+	return refs, nil
+}
 
-	if link != "" {
-		query += fmt.Sprintf("link = $%d, ", argIndex)
-		args = append(args, link)
-		argIndex++
+// extraArtists returns the artists of a song other than its main group.
+func (s *Store) extraArtists(tx *sql.Tx, songID int) ([]artistRef, error) {
+	rows, err := tx.Query(`SELECT groupId, role FROM song_artists WHERE songId = $1 AND position > 0 ORDER BY position`, songID)
+	if err != nil {
+		return nil, err
 	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
 
-	if len(args) == 0 {
-		s.log.Warn("No fields to update", "operation", op)
-		return fmt.Errorf("no fields to update")
+	var refs []artistRef
+	for rows.Next() {
+		var ref artistRef
+		if err := rows.Scan(&ref.groupID, &ref.role); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
 	}
+	return refs, rows.Err()
+}
 
-	query = query[:len(query)-2]
+// setSongArtists replaces the artist list of a song. The main group is kept
+// as the first primary artist. Groups that lose their last song are kept;
+// PurgeTrash removes the groups left without songs.
+func (s *Store) setSongArtists(tx *sql.Tx, songID, mainGroupID int, refs []artistRef) error {
+	const op = "song.setSongArtists"
+
+	if _, err := tx.Exec(`DELETE FROM song_artists WHERE songId = $1`, songID); err != nil {
+		s.log.Error("Error clearing song artists", "operation", op, "songId", songID, logger.Err(err))
+		return err
+	}
 
-	query += ` WHERE id = $` + fmt.Sprintf("%d", argIndex)
-	args = append(args, id)
+	query := `INSERT INTO song_artists (songId, groupId, role, position) VALUES ($1, $2, $3, $4)
+              ON CONFLICT DO NOTHING`
+	refs = append([]artistRef{{groupID: mainGroupID, role: types.ArtistRolePrimary}}, refs...)
+	for position, ref := range refs {
+		if _, err := tx.Exec(query, songID, ref.groupID, ref.role, position); err != nil {
+			s.log.Error("Error adding song artist", "operation", op, "songId", songID, "groupId", ref.groupID, logger.Err(err))
+			return err
+		}
+	}
+	return nil
+}
 
-	s.log.Info("Executing update query", "operation", op, "query", query, "args", args)
+// deleteOrphanGroup removes the group if no songs reference it anymore,
+// either as their main group or as one of their artists, and it has no
+// albums, which would be deleted with it.
+func (s *Store) deleteOrphanGroup(tx *sql.Tx, groupID int) error {
+	const op = "song.deleteOrphanGroup"
 
-	result, err := s.db.Exec(query, args...)
+	query := `DELETE FROM groups g
+              WHERE g.id = $1
+                AND NOT EXISTS (SELECT 1 FROM songs WHERE songGroupId = g.id)
+                AND NOT EXISTS (SELECT 1 FROM song_artists WHERE groupId = g.id)
+                AND NOT EXISTS (SELECT 1 FROM albums WHERE groupId = g.id)`
+	result, err := tx.Exec(query, groupID)
 	if err != nil {
-		s.log.Error("Error executing update query", "operation", op, "query", query, "args", args, logger.Err(err))
+		s.log.Error("Error deleting orphan group", "operation", op, "groupId", groupID, logger.Err(err))
 		return err
 	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		s.log.Info("Deleted orphan group", "operation", op, "groupId", groupID)
+	}
+	return nil
+}
 
-	rowsAffected, err := result.RowsAffected()
+func (s *Store) GetSongLyrics(id, offset, limit int) ([]types.Verse, int, error) {
+	const op = "song.GetSongLyrics"
+	s.log.Debug("Fetching song lyrics", "operation", op, "id", id, "offset", offset, "limit", limit)
+
+	var total int
+	err := s.db.QueryRow(`SELECT COALESCE(cardinality(songLyrics), 0) FROM songs WHERE id = $1 AND deletedAt IS NULL`, id).Scan(&total)
 	if err != nil {
-		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.Warn("Song not found", "operation", op, "id", id)
+			return nil, 0, types.ErrSongNotFound
+		}
+		s.log.Error("Error counting verses", "operation", op, "id", id, logger.Err(err))
+		return nil, 0, err
 	}
 
-	s.log.Info("Rows affected", "operation", op, "rows_affected", rowsAffected)
+	query := `SELECT v.idx - 1, v.verse
+              FROM songs s, unnest(s.songLyrics) WITH ORDINALITY AS v(verse, idx)
+              WHERE s.id = $1
+              ORDER BY v.idx
+              OFFSET $2 LIMIT $3`
+	rows, err := s.db.Query(query, id, offset, limit)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
 
-	if rowsAffected == 0 {
-		s.log.Warn("No changes made to the song info", "operation", op, "id", id)
-		return fmt.Errorf("no changes made to the song with ID %d", id)
+	verses := make([]types.Verse, 0, limit)
+	for rows.Next() {
+		var verse types.Verse
+		if err := rows.Scan(&verse.Index, &verse.Text); err != nil {
+			s.log.Error("Error scanning verse", "operation", op, logger.Err(err))
+			return nil, 0, err
+		}
+		verses = append(verses, verse)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating verses", "operation", op, logger.Err(err))
+		return nil, 0, err
 	}
 
-	if oldGroupId > -1 {
-		var count int
-		query = `SELECT COUNT(*) FROM songs WHERE songGroupId = $1`
-		err = s.db.QueryRow(query, oldGroupId).Scan(&count)
-		if err != nil {
-			s.log.Error("Error counting songs for old group", "operation", op, "oldGroupId", oldGroupId, logger.Err(err))
-		} else if count == 0 {
-			// No songs left in the old group, delete the group
-			query = `DELETE FROM groups WHERE id = $1`
-			_, err := s.db.Exec(query, oldGroupId)
-			if err != nil {
-				s.log.Error("Error deleting old group", "operation", op, "oldGroupId", oldGroupId, logger.Err(err))
+	s.log.Debug("Fetched song lyrics", "operation", op, "id", id, "verses_count", len(verses), "total", total)
+	return verses, total, nil
+}
+
+// AddSongTags attaches genres and tags to a song, creating them as needed.
+// Tags the song already has are left as they are.
+func (s *Store) AddSongTags(id int, genres, tags []string) error {
+	const op = "song.AddSongTags"
+	s.log.Info("Adding song tags", "operation", op, "id", id, "genres", genres, "tags", tags)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		if err := s.lockSong(tx, id); err != nil {
+			return err
+		}
+
+		for kind, names := range map[string][]string{types.TagKindGenre: genres, types.TagKindTag: tags} {
+			for _, name := range names {
+				var tagID int
+				query := `INSERT INTO tags (name, kind) VALUES ($1, $2)
+                          ON CONFLICT (kind, name) DO UPDATE SET name = EXCLUDED.name
+                          RETURNING id`
+				if err := tx.QueryRow(query, normalize.Tag(name), kind).Scan(&tagID); err != nil {
+					s.log.Error("Error resolving tag", "operation", op, "name", name, "kind", kind, logger.Err(err))
+					return err
+				}
+
+				query = `INSERT INTO song_tags (songId, tagId) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+				if _, err := tx.Exec(query, id, tagID); err != nil {
+					s.log.Error("Error tagging song", "operation", op, "name", name, "kind", kind, logger.Err(err))
+					return err
+				}
+			}
+		}
+
+		s.log.Info("Song tags added successfully", "operation", op, "id", id)
+		return nil
+	})
+}
+
+// RemoveSongTags detaches genres and tags from a song. Tags no longer used by
+// any song are deleted.
+func (s *Store) RemoveSongTags(id int, genres, tags []string) error {
+	const op = "song.RemoveSongTags"
+	s.log.Info("Removing song tags", "operation", op, "id", id, "genres", genres, "tags", tags)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		if err := s.lockSong(tx, id); err != nil {
+			return err
+		}
+
+		for kind, names := range map[string][]string{types.TagKindGenre: genres, types.TagKindTag: tags} {
+			if len(names) == 0 {
+				continue
+			}
+			normalized := make([]string, len(names))
+			for i, name := range names {
+				normalized[i] = normalize.Tag(name)
+			}
+
+			query := `DELETE FROM song_tags st
+                      USING tags t
+                      WHERE st.tagId = t.id AND st.songId = $1 AND t.kind = $2 AND t.name = ANY($3)`
+			if _, err := tx.Exec(query, id, kind, pq.Array(normalized)); err != nil {
+				s.log.Error("Error untagging song", "operation", op, "kind", kind, logger.Err(err))
+				return err
+			}
+
+			query = `DELETE FROM tags t
+                     WHERE t.kind = $1 AND t.name = ANY($2)
+                       AND NOT EXISTS (SELECT 1 FROM song_tags st WHERE st.tagId = t.id)`
+			if _, err := tx.Exec(query, kind, pq.Array(normalized)); err != nil {
+				s.log.Error("Error deleting unused tags", "operation", op, "kind", kind, logger.Err(err))
 				return err
 			}
-			s.log.Info("Deleted old group", "operation", op, "oldGroupId", oldGroupId)
 		}
-	}
 
-	s.log.Info("Song info updated successfully", "operation", op, "id", id)
-	return nil
+		s.log.Info("Song tags removed successfully", "operation", op, "id", id)
+		return nil
+	})
 }
 
-func (s *Store) AddSong(song, group string, songDetails *types.SongDetail, songLyrics []string) error {
-	const op = "song.AddSong"
-	s.log.Info("Adding new song", "operation", op, "name", song, "group", group)
+// GetTags lists genres and tags with the number of songs using each. An empty
+// kind lists both.
+func (s *Store) GetTags(kind string) ([]types.TagCount, error) {
+	const op = "song.GetTags"
+	s.log.Debug("Fetching tags", "operation", op, "kind", kind)
 
-	var groupID int
-	err := s.db.QueryRow(`SELECT id FROM groups WHERE groupName = $1`, group).Scan(&groupID)
+	query := `SELECT t.name, t.kind, COUNT(st.songId)
+              FROM tags t
+              LEFT JOIN song_tags st ON st.tagId = t.id
+                   AND st.songId IN (SELECT id FROM songs WHERE deletedAt IS NULL)
+              WHERE $1 = '' OR t.kind = $1
+              GROUP BY t.id
+              ORDER BY COUNT(st.songId) DESC, t.name`
+	rows, err := s.db.Query(query, kind)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			err = s.db.QueryRow(`INSERT INTO groups (groupName) VALUES ($1) RETURNING id`, group).Scan(&groupID)
-			if err != nil {
-				s.log.Error("Error creating group", "operation", op, "group", group, logger.Err(err))
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	tags := []types.TagCount{}
+	for rows.Next() {
+		var tag types.TagCount
+		if err := rows.Scan(&tag.Name, &tag.Kind, &tag.Count); err != nil {
+			s.log.Error("Error scanning tag", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating tags", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Fetched tags", "operation", op, "tags_count", len(tags))
+	return tags, nil
+}
+
+// lockSong locks the song row for the rest of the transaction, returning
+// ErrSongNotFound when it doesn't exist.
+func (s *Store) lockSong(tx *sql.Tx, id int) error {
+	var songID int
+	err := tx.QueryRow(`SELECT id FROM songs WHERE id = $1 AND deletedAt IS NULL FOR UPDATE`, id).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.log.Warn("Song not found", "operation", "song.lockSong", "id", id)
+		return types.ErrSongNotFound
+	}
+	return err
+}
+
+// AddSongRelation records that songID is a version of kind (cover, remix,
+// live or translation) of originalID.
+func (s *Store) AddSongRelation(songID, originalID int, kind string) error {
+	const op = "song.AddSongRelation"
+	s.log.Info("Adding song relation", "operation", op, "songId", songID, "originalId", originalID, "kind", kind)
+
+	return db.WithTx(s.db, func(tx *sql.Tx) error {
+		for _, id := range []int{songID, originalID} {
+			if err := s.lockSong(tx, id); err != nil {
+				if errors.Is(err, types.ErrSongNotFound) {
+					return fmt.Errorf("song with ID %d: %w", id, err)
+				}
 				return err
 			}
-		} else {
-			s.log.Error("Error checking group", "operation", op, "group", group, logger.Err(err))
+		}
+
+		query := `INSERT INTO song_relations (songId, originalId, kind) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(query, songID, originalID, kind); err != nil {
+			if db.IsUniqueViolation(err) {
+				s.log.Warn("Relation already exists", "operation", op, "songId", songID, "originalId", originalID, "kind", kind)
+				return types.ErrRelationExists
+			}
+			s.log.Error("Error adding relation", "operation", op, logger.Err(err))
 			return err
 		}
+
+		s.log.Info("Song relation added successfully", "operation", op, "songId", songID, "originalId", originalID)
+		return nil
+	})
+}
+
+func (s *Store) DeleteSongRelation(songID, originalID int, kind string) error {
+	const op = "song.DeleteSongRelation"
+	s.log.Info("Deleting song relation", "operation", op, "songId", songID, "originalId", originalID, "kind", kind)
+
+	query := `DELETE FROM song_relations WHERE songId = $1 AND originalId = $2 AND kind = $3`
+	result, err := s.db.Exec(query, songID, originalID, kind)
+	if err != nil {
+		s.log.Error("Error deleting relation", "operation", op, logger.Err(err))
+		return err
 	}
 
-	query := `INSERT INTO songs (songName, songGroupId, songLyrics, published, link) 
-              VALUES ($1, $2, $3, $4, $5)`
-	_, err = s.db.Exec(query, song, groupID, pq.Array(songLyrics), songDetails.ReleaseDate, songDetails.Link)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		s.log.Error("Error adding song", "operation", op, "name", song, "group", group, logger.Err(err))
+		s.log.Error("Error retrieving rows affected", "operation", op, logger.Err(err))
 		return err
 	}
+	if rowsAffected == 0 {
+		s.log.Warn("Relation not found", "operation", op, "songId", songID, "originalId", originalID, "kind", kind)
+		return types.ErrRelationNotFound
+	}
 
-	s.log.Info("Song added successfully", "operation", op, "name", song, "group", group)
+	s.log.Info("Song relation deleted successfully", "operation", op, "songId", songID, "originalId", originalID)
 	return nil
 }
+
+// GetSongRelations lists the direct relations of a song in both directions:
+// the originals it was derived from and the versions derived from it.
+func (s *Store) GetSongRelations(songID int) ([]types.SongRelation, error) {
+	const op = "song.GetSongRelations"
+	s.log.Debug("Fetching song relations", "operation", op, "songId", songID)
+
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM songs WHERE id = $1 AND deletedAt IS NULL)`, songID).Scan(&exists); err != nil {
+		s.log.Error("Error checking song existence", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	if !exists {
+		return nil, types.ErrSongNotFound
+	}
+
+	query := `SELECT songId, originalId, kind
+              FROM song_relations r
+              WHERE (songId = $1 OR originalId = $1)
+                AND NOT EXISTS (SELECT 1 FROM songs s WHERE s.id IN (r.songId, r.originalId) AND s.deletedAt IS NOT NULL)
+              ORDER BY songId, originalId, kind`
+	rows, err := s.db.Query(query, songID)
+	if err != nil {
+		s.log.Error("Error executing query", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	relations := []types.SongRelation{}
+	for rows.Next() {
+		var relation types.SongRelation
+		if err := rows.Scan(&relation.SongID, &relation.OriginalID, &relation.Kind); err != nil {
+			s.log.Error("Error scanning relation", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		relations = append(relations, relation)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Error iterating relations", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	return relations, nil
+}
+
+// maxVersionDepth bounds how far GetSongVersions walks the relation graph.
+const maxVersionDepth = 10
+
+// GetSongVersions walks the relation graph in both directions from a song and
+// returns every connected song once, at its shortest distance. Relation
+// describes each song relative to its parent in the walk: "original" when it
+// is the parent's original, otherwise the kind of version it is. The walk is
+// breadth-first, one query per level, and never revisits a song, so dense
+// clusters of covers and remixes stay cheap.
+func (s *Store) GetSongVersions(songID int) ([]types.SongVersion, error) {
+	const op = "song.GetSongVersions"
+	s.log.Debug("Fetching song versions", "operation", op, "songId", songID)
+
+	start := types.SongVersion{ID: songID}
+	query := `SELECT s.songName, g.groupName
+              FROM songs s
+              JOIN groups g ON s.songGroupId = g.id
+              WHERE s.id = $1 AND s.deletedAt IS NULL`
+	err := s.db.QueryRow(query, songID).Scan(&start.SongName, &start.Group)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.log.Warn("Song not found", "operation", op, "songId", songID)
+		return nil, types.ErrSongNotFound
+	}
+	if err != nil {
+		s.log.Error("Error fetching song", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	// Songs one step away from the frontier that weren't reached yet, each
+	// with the lowest parent it can be reached from
+	query = `SELECT DISTINCT ON (e.toId) e.toId, s.songName, g.groupName, e.fromId, e.relation
+             FROM (SELECT songId AS fromId, originalId AS toId, 'original'::varchar AS relation
+                   FROM song_relations WHERE songId = ANY($1)
+                   UNION ALL
+                   SELECT originalId, songId, kind
+                   FROM song_relations WHERE originalId = ANY($1)) e
+             JOIN songs s ON s.id = e.toId AND s.deletedAt IS NULL
+             JOIN groups g ON s.songGroupId = g.id
+             WHERE NOT e.toId = ANY($2)
+             ORDER BY e.toId, e.fromId, e.relation`
+
+	versions := []types.SongVersion{start}
+	visited := []int{songID}
+	frontier := []int{songID}
+	for depth := 1; depth <= maxVersionDepth && len(frontier) > 0; depth++ {
+		rows, err := s.db.Query(query, pq.Array(frontier), pq.Array(visited))
+		if err != nil {
+			s.log.Error("Error executing query", "operation", op, "depth", depth, logger.Err(err))
+			return nil, err
+		}
+		var next []int
+		for rows.Next() {
+			version := types.SongVersion{Depth: depth}
+			if err := rows.Scan(&version.ID, &version.SongName, &version.Group, &version.ParentID, &version.Relation); err != nil {
+				_ = rows.Close()
+				s.log.Error("Error scanning version", "operation", op, logger.Err(err))
+				return nil, err
+			}
+			versions = append(versions, version)
+			next = append(next, version.ID)
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			s.log.Error("Error iterating versions", "operation", op, logger.Err(err))
+			return nil, err
+		}
+		visited = append(visited, next...)
+		frontier = next
+	}
+
+	s.log.Debug("Fetched song versions", "operation", op, "songId", songID, "versions_count", len(versions))
+	return versions, nil
+}
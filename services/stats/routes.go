@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/genryusaishigikuni/muse_lib/config"
+	"github.com/genryusaishigikuni/muse_lib/httpapi"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRecentSongs is the number of recently added songs listed unless the
+// request asks for another number.
+const defaultRecentSongs = 10
+
+type Handler struct {
+	store types.StatsStore
+	cache *statsCache
+	logs  *slog.Logger
+}
+
+func NewHandler(statsStore types.StatsStore, env string) *Handler {
+	return &Handler{
+		store: statsStore,
+		cache: newStatsCache(time.Duration(config.Envs.StatsCacheSeconds) * time.Second),
+		logs:  logger.SetupLogger(env),
+	}
+}
+
+// RegisterRoutes registers the statistics routes.
+//
+// @Summary Register statistics routes
+// @Description Adds the library statistics route to the given router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/stats", h.HandleGetStats).Methods("GET")
+}
+
+// HandleGetStats returns statistics about the library.
+//
+// @Summary Library statistics
+// @Description Returns the number of songs, groups, albums and verses, the average number of verses per song, the songs without lyrics, link or publication date, the groups with the most songs, the songs published per year and per decade and the most recently added songs, followed by songs added before creation times were recorded, whose createdAt is null. Songs in the trash are not counted. Results are cached for a configurable number of seconds; generatedAt tells when they were computed.
+// @Tags stats
+// @Produce json
+// @Param top query int false "Number of groups with the most songs to list, capped at the maximum page size"
+// @Param recent query int false "Number of recently added songs to list, capped at the maximum page size"
+// @Success 200 {object} types.LibraryStats "Statistics computed successfully"
+// @Failure 400 {string} string "Invalid query parameter"
+// @Failure 500 {string} string "Failed to compute statistics"
+// @Router /stats [get]
+func (h *Handler) HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HandleGetStats"
+	h.logs.Info("Starting request", "operation", op, "method", r.Method, "query_params", r.URL.Query())
+
+	query := r.URL.Query()
+	top, err := countParam(query, "top", config.Envs.StatsTopGroups)
+	if err != nil {
+		h.logs.Error("Invalid top value", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	recent, err := countParam(query, "recent", defaultRecentSongs)
+	if err != nil {
+		h.logs.Error("Invalid recent value", "operation", op, logger.Err(err))
+		httpapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key := fmt.Sprintf("%d/%d", top, recent)
+	stats, ok := h.cache.get(key)
+	if !ok {
+		stats, err = h.store.GetStats(top, recent)
+		if err != nil {
+			h.logs.Error("Error computing statistics", "operation", op, logger.Err(err))
+			httpapi.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		h.cache.put(key, stats)
+	}
+
+	h.logs.Debug("Statistics retrieved", "operation", op, "cached", ok, "songs", stats.Totals.Songs)
+	if err := httpapi.WriteJSON(w, http.StatusOK, stats); err != nil {
+		h.logs.Error("Error writing response", "operation", op, logger.Err(err))
+	}
+}
+
+// countParam reads a positive integer query parameter, capped at the maximum
+// page size.
+func countParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return min(fallback, config.Envs.MaxPageSize), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid value for %s: must be a positive integer", name)
+	}
+	return min(n, config.Envs.MaxPageSize), nil
+}
+
+// statsCache keeps computed statistics for ttl, per combination of
+// parameters. A zero ttl disables it.
+type statsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedStats
+}
+
+type cachedStats struct {
+	stats   *types.LibraryStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, entries: make(map[string]cachedStats)}
+}
+
+func (c *statsCache) get(key string) (*types.LibraryStats, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.stats, true
+}
+
+func (c *statsCache) put(key string, stats *types.LibraryStats) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// Expired entries are dropped here, so the cache stays as small as the
+	// number of parameter combinations in use
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedStats{stats: stats, expires: now.Add(c.ttl)}
+}
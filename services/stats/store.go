@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"github.com/genryusaishigikuni/muse_lib/logger"
+	"github.com/genryusaishigikuni/muse_lib/types"
+	"log/slog"
+	"time"
+)
+
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func NewStore(db *sql.DB, env string) *Store {
+	log := logger.SetupLogger(env)
+	const op = "stats.NewStore"
+	log.Debug("Initializing new store", "operation", op)
+	return &Store{db: db, log: log}
+}
+
+// GetStats computes the library statistics with the topGroups groups that
+// have the most songs and the recentSongs songs added last. Songs in the
+// trash are left out. All figures come from a single snapshot of the
+// database.
+func (s *Store) GetStats(topGroups, recentSongs int) (*types.LibraryStats, error) {
+	const op = "stats.GetStats"
+	s.log.Debug("Computing library statistics", "operation", op, "top_groups", topGroups, "recent_songs", recentSongs)
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		s.log.Error("Error starting transaction", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	// Nothing is written, so the transaction is never committed
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stats := &types.LibraryStats{GeneratedAt: time.Now().UTC()}
+
+	query := `SELECT COUNT(*),
+                     COUNT(DISTINCT songGroupId),
+                     (SELECT COUNT(*) FROM albums),
+                     COALESCE(SUM(cardinality(songLyrics)), 0),
+                     COUNT(*) FILTER (WHERE COALESCE(cardinality(songLyrics), 0) = 0),
+                     COUNT(*) FILTER (WHERE COALESCE(link, '') = ''),
+                     COUNT(*) FILTER (WHERE published IS NULL),
+                     COALESCE(AVG(COALESCE(cardinality(songLyrics), 0)), 0)
+              FROM songs
+              WHERE deletedAt IS NULL`
+	totals := &stats.Totals
+	err = tx.QueryRow(query).Scan(&totals.Songs, &totals.Groups, &totals.Albums, &totals.Verses,
+		&totals.WithoutLyrics, &totals.WithoutLink, &totals.WithoutDate, &stats.AverageVerses)
+	if err != nil {
+		s.log.Error("Error computing totals", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	query = `SELECT g.id, g.groupName, COUNT(*) AS songs
+             FROM songs s
+             JOIN groups g ON s.songGroupId = g.id
+             WHERE s.deletedAt IS NULL
+             GROUP BY g.id
+             ORDER BY songs DESC, g.groupName, g.id
+             LIMIT $1`
+	stats.TopGroups = []types.GroupSongCount{}
+	err = s.query(tx, query, []any{topGroups}, func(rows *sql.Rows) error {
+		var group types.GroupSongCount
+		if err := rows.Scan(&group.ID, &group.Name, &group.Songs); err != nil {
+			return err
+		}
+		stats.TopGroups = append(stats.TopGroups, group)
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Error counting songs per group", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	if stats.SongsPerYear, err = s.periodCounts(tx, 1); err != nil {
+		s.log.Error("Error counting songs per year", "operation", op, logger.Err(err))
+		return nil, err
+	}
+	if stats.SongsPerDecade, err = s.periodCounts(tx, 10); err != nil {
+		s.log.Error("Error counting songs per decade", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	query = `SELECT s.id, s.songName, g.groupName, s.createdAt
+             FROM songs s
+             JOIN groups g ON s.songGroupId = g.id
+             WHERE s.deletedAt IS NULL
+             ORDER BY s.createdAt DESC NULLS LAST, s.id DESC
+             LIMIT $1`
+	stats.RecentSongs = []types.RecentSong{}
+	err = s.query(tx, query, []any{recentSongs}, func(rows *sql.Rows) error {
+		var song types.RecentSong
+		if err := rows.Scan(&song.ID, &song.SongName, &song.Group, &song.CreatedAt); err != nil {
+			return err
+		}
+		stats.RecentSongs = append(stats.RecentSongs, song)
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Error fetching recent songs", "operation", op, logger.Err(err))
+		return nil, err
+	}
+
+	s.log.Debug("Computed library statistics", "operation", op, "songs", totals.Songs)
+	return stats, nil
+}
+
+// periodCounts counts the published songs per period of the given number of
+// years, oldest first. A period is named after its first year.
+func (s *Store) periodCounts(tx *sql.Tx, years int) ([]types.PeriodCount, error) {
+	query := `SELECT (EXTRACT(YEAR FROM published)::int / $1) * $1 AS period, COUNT(*)
+              FROM songs
+              WHERE deletedAt IS NULL AND published IS NOT NULL
+              GROUP BY period
+              ORDER BY period`
+	counts := []types.PeriodCount{}
+	err := s.query(tx, query, []any{years}, func(rows *sql.Rows) error {
+		var count types.PeriodCount
+		if err := rows.Scan(&count.Period, &count.Songs); err != nil {
+			return err
+		}
+		counts = append(counts, count)
+		return nil
+	})
+	return counts, err
+}
+
+// query runs a query in tx and calls scan for every row.
+func (s *Store) query(tx *sql.Tx, query string, args []any, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -1,13 +1,91 @@
 package types
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
 	"time"
 )
 
+// DateLayout is the only date format accepted by song date filters.
+const DateLayout = "2006-01-02"
+
+var (
+	ErrSongNotFound     = errors.New("song not found")
+	ErrInvalidFilter    = errors.New("invalid filter")
+	ErrDuplicateSong    = errors.New("song already exists in this group")
+	ErrRelationExists   = errors.New("relation already exists")
+	ErrRelationNotFound = errors.New("relation not found")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrVersionConflict  = errors.New("song was changed by another request")
+	ErrInvalidPatch     = errors.New("invalid patch")
+	ErrInvalidImport    = errors.New("invalid import file")
+
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group with this name already exists")
+	ErrGroupNotEmpty = errors.New("group still has songs")
+	ErrAliasNotFound = errors.New("alias not found")
+
+	ErrAlbumNotFound = errors.New("album not found")
+	ErrTrackTaken    = errors.New("track number already used on this album")
+
+	ErrPlaylistNotFound = errors.New("playlist not found")
+	ErrEntryNotFound    = errors.New("playlist entry not found")
+	ErrDuplicateEntry   = errors.New("song is already on this playlist")
+	ErrInvalidOrder     = errors.New("order must list every entry of the playlist exactly once")
+
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user with this name already exists")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+)
+
+// DuplicateSongError is returned when a group already has a song with the
+// same normalized name.
+type DuplicateSongError struct {
+	ExistingID int
+}
+
+func (e *DuplicateSongError) Error() string {
+	return fmt.Sprintf("%s (existing song ID %d)", ErrDuplicateSong, e.ExistingID)
+}
+
+func (e *DuplicateSongError) Unwrap() error {
+	return ErrDuplicateSong
+}
+
+// VersionConflictError is returned when a song is changed with an expected
+// version that is no longer current.
+type VersionConflictError struct {
+	Current int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s (current version %d)", ErrVersionConflict, e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+const (
+	ArtistRolePrimary  = "primary"
+	ArtistRoleFeatured = "featured"
+	ArtistRoleRemixer  = "remixer"
+)
+
+type SongArtist struct {
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
 type SongAddPayload struct {
-	SongName string `json:"song"`
-	Group    string `json:"group"`
+	SongName string       `json:"song"`
+	Group    string       `json:"group"`
+	Artists  []SongArtist `json:"artists,omitempty"`
 }
 
 type SongDeletePayload struct {
@@ -18,20 +96,609 @@ type SongDetail struct {
 	ReleaseDate string `json:"releaseDate"`
 	Text        string `json:"text"`
 	Link        string `json:"link"`
+	// Duration is the length in seconds, when the external API knows it.
+	Duration int `json:"duration,omitempty"`
+}
+
+// VerseMatch is a verse matching a full-text search. Snippet is HTML: the
+// verse text is escaped and the matched words are wrapped in <mark> tags.
+type VerseMatch struct {
+	Index   int    `json:"index"`
+	Snippet string `json:"snippet"`
+}
+
+type SongAlbum struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
 }
 
 type Song struct {
-	ID         int       `json:"id"`
-	SongName   string    `json:"song"`
-	Group      string    `json:"group"`
-	SongLyrics []string  `json:"songLyrics"`
-	Published  time.Time `json:"published"`
-	Link       string    `json:"link"`
+	ID         int          `json:"id"`
+	SongName   string       `json:"song"`
+	Group      string       `json:"group"`
+	SongLyrics []string     `json:"songLyrics"`
+	Published  *time.Time   `json:"published"`
+	Link       string       `json:"link"`
+	Version    int          `json:"version,omitempty"`
+	ETag       string       `json:"etag,omitempty"`
+	Artists    []SongArtist `json:"artists,omitempty"`
+	Album      *SongAlbum   `json:"album,omitempty"`
+	Genres     []string     `json:"genres,omitempty"`
+	Tags       []string     `json:"tags,omitempty"`
+	Rank       float64      `json:"rank,omitempty"`
+	Score      float64      `json:"score,omitempty"`
+	Matches    []VerseMatch `json:"matches,omitempty"`
+	DeletedAt  *time.Time   `json:"deletedAt,omitempty"`
+}
+
+// PatchField is a member of a JSON merge patch (RFC 7396). Set reports
+// whether the member was present at all, Null whether it was an explicit
+// null that clears the field.
+type PatchField[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// PatchValue returns a field set to v.
+func PatchValue[T any](v T) PatchField[T] {
+	return PatchField[T]{Set: true, Value: v}
+}
+
+func (f *PatchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		f.Null = true
+		var zero T
+		f.Value = zero
+		return nil
+	}
+	return json.Unmarshal(data, &f.Value)
+}
+
+// SongPatch is a change to a song. Absent fields stay unchanged. Null clears
+// the lyrics, publication date, link and additional artists; the name and
+// group can't be cleared.
+type SongPatch struct {
+	SongName   PatchField[string]       `json:"song"`
+	Group      PatchField[string]       `json:"group"`
+	Artists    PatchField[[]SongArtist] `json:"artists"`
+	SongLyrics PatchField[[]string]     `json:"songLyrics"`
+	Published  PatchField[time.Time]    `json:"published"`
+	Link       PatchField[string]       `json:"link"`
+}
+
+type Verse struct {
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+}
+
+type PageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type SongPage struct {
+	Items      []Song    `json:"items"`
+	Total      *int      `json:"total,omitempty"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Links      PageLinks `json:"links"`
+}
+
+type TrashPage struct {
+	Items         []Song    `json:"items"`
+	Total         int       `json:"total"`
+	Offset        int       `json:"offset"`
+	Limit         int       `json:"limit"`
+	RetentionDays int       `json:"retentionDays"`
+	Links         PageLinks `json:"links"`
+}
+
+type PurgeResult struct {
+	Purged int       `json:"purged"`
+	Cutoff time.Time `json:"cutoff"`
+}
+
+type Group struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	SongCount int         `json:"songCount"`
+	Aliases   []string    `json:"aliases,omitempty"`
+	Songs     []GroupSong `json:"songs,omitempty"`
+}
+
+// GroupSong is a song a group takes part in. Group is the song's main group;
+// Roles lists what the listed group does on the song.
+type GroupSong struct {
+	Song
+	Roles []string `json:"roles"`
+}
+
+type GroupPage struct {
+	Items  []Group   `json:"items"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+	Links  PageLinks `json:"links"`
+}
+
+type GroupRenamePayload struct {
+	Name string `json:"name"`
+}
+
+type GroupAliasPayload struct {
+	Alias string `json:"alias"`
+}
+
+type GroupMergePayload struct {
+	TargetID  int   `json:"target"`
+	SourceIDs []int `json:"sources"`
+}
+
+type GroupMergeResult struct {
+	TargetID   int   `json:"target"`
+	MergedIDs  []int `json:"merged"`
+	SongsMoved int   `json:"songsMoved"`
+}
+
+type Album struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	GroupID     int    `json:"groupId"`
+	Group       string `json:"group"`
+	ReleaseDate string `json:"releaseDate,omitempty"`
+	CoverURL    string `json:"coverUrl,omitempty"`
+	TrackCount  int    `json:"trackCount"`
+	Tracks      []Song `json:"tracks,omitempty"`
+}
+
+type AlbumPage struct {
+	Items  []Album   `json:"items"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+	Links  PageLinks `json:"links"`
+}
+
+type AlbumCreatePayload struct {
+	Title       string `json:"title"`
+	GroupID     int    `json:"groupId"`
+	ReleaseDate string `json:"releaseDate,omitempty"`
+	CoverURL    string `json:"coverUrl,omitempty"`
+}
+
+type AlbumTrackPayload struct {
+	SongID      int `json:"songId"`
+	TrackNumber int `json:"trackNumber,omitempty"`
+}
+
+const (
+	TagKindGenre = "genre"
+	TagKindTag   = "tag"
+)
+
+type SongTagsPayload struct {
+	Genres []string `json:"genres,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// Outcomes of an imported row. Valid rows of a dry run are reported as
+// ImportValid instead of being created.
+const (
+	ImportCreated = "created"
+	ImportValid   = "valid"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportRowResult is the outcome of one row of an import file. Line is the
+// line the row starts on. ID is the created song, or the existing one a
+// skipped row duplicates.
+type ImportRowResult struct {
+	Line     int    `json:"line"`
+	SongName string `json:"song,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Status   string `json:"status"`
+	ID       int    `json:"id,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Valid   int               `json:"valid"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// ExportedSong is a song as written by an export. Song, group, lyrics,
+// published and link use the names of the import columns; published is
+// formatted with DateLayout and empty when unknown.
+type ExportedSong struct {
+	ID        int          `json:"id"`
+	SongName  string       `json:"song"`
+	Group     string       `json:"group"`
+	Lyrics    []string     `json:"lyrics"`
+	Published string       `json:"published"`
+	Link      string       `json:"link"`
+	Version   int          `json:"version"`
+	Artists   []SongArtist `json:"artists"`
+	Album     *SongAlbum   `json:"album,omitempty"`
+	Genres    []string     `json:"genres"`
+	Tags      []string     `json:"tags"`
+}
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+const (
+	RelationCover       = "cover"
+	RelationRemix       = "remix"
+	RelationLive        = "live"
+	RelationTranslation = "translation"
+
+	// RelationOriginal marks the original a version was derived from when
+	// walking the version graph.
+	RelationOriginal = "original"
+)
+
+type SongRelationPayload struct {
+	OriginalID int    `json:"originalId"`
+	Kind       string `json:"kind"`
+}
+
+type SongRelation struct {
+	SongID     int    `json:"songId"`
+	OriginalID int    `json:"originalId"`
+	Kind       string `json:"kind"`
+}
+
+type SongVersion struct {
+	ID       int    `json:"id"`
+	SongName string `json:"song"`
+	Group    string `json:"group"`
+	ParentID int    `json:"parentId,omitempty"`
+	Relation string `json:"relation,omitempty"`
+	Depth    int    `json:"depth"`
+}
+
+// Actions recorded in a song's revision history.
+const (
+	RevisionCreate = "create"
+	RevisionUpdate = "update"
+	RevisionRevert = "revert"
+)
+
+// SongSnapshot is the editable state of a song as stored with a revision.
+// Published is formatted with DateLayout and empty when unknown; the first
+// artist is the main group.
+type SongSnapshot struct {
+	SongName   string       `json:"song"`
+	Group      string       `json:"group"`
+	Artists    []SongArtist `json:"artists"`
+	SongLyrics []string     `json:"songLyrics"`
+	Published  string       `json:"published"`
+	Link       string       `json:"link"`
+}
+
+type SongRevision struct {
+	Revision     int           `json:"revision"`
+	SongID       int           `json:"songId"`
+	Action       string        `json:"action"`
+	ChangedBy    string        `json:"changedBy,omitempty"`
+	ChangedAt    time.Time     `json:"changedAt"`
+	Changes      []string      `json:"changes"`
+	RevertedFrom int           `json:"revertedFrom,omitempty"`
+	Snapshot     *SongSnapshot `json:"snapshot,omitempty"`
+}
+
+// FieldChange is a changed field of a revision diff. Lyrics are reported
+// without values; their changes are listed verse by verse.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// Operations of a verse diff.
+const (
+	VerseAdded   = "added"
+	VerseRemoved = "removed"
+)
+
+// VerseChange is a verse added or removed between two revisions. OldIndex
+// and NewIndex are the zero-based positions in the older and newer lyrics.
+type VerseChange struct {
+	Op       string `json:"op"`
+	OldIndex *int   `json:"oldIndex,omitempty"`
+	NewIndex *int   `json:"newIndex,omitempty"`
+	Text     string `json:"text"`
+}
+
+type RevisionDiff struct {
+	SongID int           `json:"songId"`
+	From   int           `json:"from"`
+	To     int           `json:"to"`
+	Fields []FieldChange `json:"fields"`
+	Verses []VerseChange `json:"verses"`
+}
+
+// Duplicate-entry policies of a playlist.
+const (
+	DuplicatesAllow  = "allow"
+	DuplicatesReject = "reject"
+	DuplicatesSkip   = "skip"
+)
+
+type Playlist struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Duplicates  string `json:"duplicates"`
+	EntryCount  int    `json:"entryCount"`
+	// Duration is the total length in seconds of the entries whose length
+	// is known; UnknownDurations counts the others.
+	Duration         int             `json:"duration"`
+	UnknownDurations int             `json:"unknownDurations"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+	Entries          []PlaylistEntry `json:"entries,omitempty"`
+}
+
+type PlaylistEntry struct {
+	ID       int       `json:"id"`
+	Position int       `json:"position"`
+	SongID   int       `json:"songId"`
+	SongName string    `json:"song"`
+	Group    string    `json:"group"`
+	Link     string    `json:"link,omitempty"`
+	Duration int       `json:"duration,omitempty"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+type PlaylistPage struct {
+	Items  []Playlist `json:"items"`
+	Total  int        `json:"total"`
+	Offset int        `json:"offset"`
+	Limit  int        `json:"limit"`
+	Links  PageLinks  `json:"links"`
+}
+
+type PlaylistPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Duplicates  string `json:"duplicates,omitempty"`
+}
+
+type PlaylistEntryPayload struct {
+	SongID   int `json:"songId"`
+	Position int `json:"position,omitempty"`
+}
+
+type PlaylistMovePayload struct {
+	Position int `json:"position"`
+}
+
+type PlaylistOrderPayload struct {
+	Entries []int `json:"entries"`
+}
+
+// Roles of API users, from least to most privileged.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserCreatePayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type LoginPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	User      User      `json:"user"`
+}
+
+// API key scopes grant read or write access to one resource.
+const (
+	ScopeSongsRead      = "songs:read"
+	ScopeSongsWrite     = "songs:write"
+	ScopeGroupsRead     = "groups:read"
+	ScopeGroupsWrite    = "groups:write"
+	ScopeAlbumsRead     = "albums:read"
+	ScopeAlbumsWrite    = "albums:write"
+	ScopePlaylistsRead  = "playlists:read"
+	ScopePlaylistsWrite = "playlists:write"
+)
+
+type APIKey struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	RequestCount int64      `json:"requestCount"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIKeyCreatePayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKeyCreated carries the plaintext key, which is only shown once.
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type LyricsPage struct {
+	SongID int       `json:"songId"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+	Verses []Verse   `json:"verses"`
+	Links  PageLinks `json:"links"`
+}
+
+// LibraryTotals counts the songs in the library, leaving out the trash.
+// Groups are the groups with at least one song; Verses adds up the verses of
+// every song.
+type LibraryTotals struct {
+	Songs         int `json:"songs"`
+	Groups        int `json:"groups"`
+	Albums        int `json:"albums"`
+	Verses        int `json:"verses"`
+	WithoutLyrics int `json:"withoutLyrics"`
+	WithoutLink   int `json:"withoutLink"`
+	WithoutDate   int `json:"withoutDate"`
+}
+
+type GroupSongCount struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Songs int    `json:"songs"`
+}
+
+// PeriodCount is the number of songs published in a year, or in the decade
+// starting with that year.
+type PeriodCount struct {
+	Period int `json:"period"`
+	Songs  int `json:"songs"`
+}
+
+// RecentSong is a song listed by when it was added. CreatedAt is nil for
+// songs added before creation times were recorded.
+type RecentSong struct {
+	ID        int        `json:"id"`
+	SongName  string     `json:"song"`
+	Group     string     `json:"group"`
+	CreatedAt *time.Time `json:"createdAt"`
+}
+
+// LibraryStats summarizes the library. Songs without a publication date are
+// left out of SongsPerYear and SongsPerDecade.
+type LibraryStats struct {
+	Totals         LibraryTotals    `json:"totals"`
+	AverageVerses  float64          `json:"averageVerses"`
+	TopGroups      []GroupSongCount `json:"topGroups"`
+	SongsPerYear   []PeriodCount    `json:"songsPerYear"`
+	SongsPerDecade []PeriodCount    `json:"songsPerDecade"`
+	RecentSongs    []RecentSong     `json:"recentSongs"`
+	GeneratedAt    time.Time        `json:"generatedAt"`
 }
 
 type SongStore interface {
-	GetSongs(filters url.Values) ([]Song, error) // Updated signature
-	DeleteSong(id int) error
-	UpdateSongInfo(id int, name, group string, lyrics interface{}, published time.Time, link string) error
-	AddSong(name, group string, songDetails *SongDetail, text []string) error
+	GetSongs(filters url.Values) (*SongPage, error)
+	ExportSongs(ctx context.Context, filters url.Values, fn func(*Song) error) error
+	DeleteSong(id, version int) error
+	GetTrash(offset, limit int) ([]Song, int, error)
+	RestoreSong(id int) error
+	PurgeTrash(cutoff time.Time) (int, error)
+	UpdateSongInfo(id, version int, patch *SongPatch, actor string) error
+	AddSong(name, group string, artists []SongArtist, songDetails *SongDetail, text []string, actor string) (int, error)
+	FindSong(name, group string) (int, error)
+	GetSongLyrics(id, offset, limit int) ([]Verse, int, error)
+	AddSongTags(id int, genres, tags []string) error
+	RemoveSongTags(id int, genres, tags []string) error
+	GetTags(kind string) ([]TagCount, error)
+	AddSongRelation(songID, originalID int, kind string) error
+	DeleteSongRelation(songID, originalID int, kind string) error
+	GetSongRelations(songID int) ([]SongRelation, error)
+	GetSongVersions(songID int) ([]SongVersion, error)
+	GetSongRevisions(songID int) ([]SongRevision, error)
+	GetSongRevision(songID, revision int) (*SongRevision, error)
+	RevertSong(songID, revision int, actor string) (int, error)
+}
+
+type StatsStore interface {
+	GetStats(topGroups, recentSongs int) (*LibraryStats, error)
+}
+
+type GroupStore interface {
+	GetGroups(offset, limit int) ([]Group, int, error)
+	GetGroup(id int) (*Group, error)
+	RenameGroup(id int, name string) error
+	DeleteGroup(id int, cascade bool) error
+	MergeGroups(targetID int, sourceIDs []int) (int, error)
+	AddGroupAlias(id int, alias string) error
+	DeleteGroupAlias(id int, alias string) error
+}
+
+type AlbumStore interface {
+	CreateAlbum(title string, groupID int, releaseDate time.Time, coverURL string) (int, error)
+	GetAlbums(groupID, offset, limit int) ([]Album, int, error)
+	GetAlbum(id int) (*Album, error)
+	AttachSong(albumID, songID, trackNumber int) error
+	DetachSong(albumID, songID int) error
+}
+
+type PlaylistStore interface {
+	CreatePlaylist(name, description, duplicates string) (int, error)
+	GetPlaylists(offset, limit int) ([]Playlist, int, error)
+	GetPlaylist(id int) (*Playlist, error)
+	UpdatePlaylist(id int, name, description, duplicates string) error
+	DeletePlaylist(id int) error
+	AddEntry(playlistID, songID, position int) (int, bool, error)
+	RemoveEntry(playlistID, entryID int) error
+	MoveEntry(playlistID, entryID, position int) error
+	ReorderEntries(playlistID int, entryIDs []int) error
+}
+
+type UserStore interface {
+	CreateUser(username, passwordHash, role string) (int, error)
+	GetUsers() ([]User, error)
+	GetUser(id int) (*User, error)
+	DeleteUser(id int) error
+	// GetCredentials returns the user and its password hash for a login.
+	GetCredentials(username string) (*User, string, error)
+	// EnsureAdmin creates the given admin unless an admin already exists.
+	EnsureAdmin(username, passwordHash string) (bool, error)
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(name, prefix, keyHash string, scopes []string, createdBy int) (*APIKey, error)
+	GetAPIKeys() ([]APIKey, error)
+	RevokeAPIKey(id int) error
+	// UseAPIKey returns the active key with the given hash and records the
+	// request against it.
+	UseAPIKey(keyHash string) (*APIKey, error)
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a context naming the caller of a request, so stores can
+// record who made a change without depending on the auth package.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the caller set by WithActor, or "" for anonymous
+// requests.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	return actor
 }